@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+
 	c "github.com/planetsp/k-drive/pkg/config"
 	log "github.com/planetsp/k-drive/pkg/logging"
 	s "github.com/planetsp/k-drive/pkg/models"
@@ -13,31 +15,36 @@ func main() {
 	log.Info("Starting k-drive")
 
 	syncInfoChannel := make(chan *s.SyncInfo)
+	ui.SetRetryHandler(sync.RetryFailedTransfers)
+	sync.SetConnectionHandler(ui.SetOnlineStatus)
+	ui.SetSelectiveSyncHandlers(sync.ListCloudFolders, sync.SetSelectedFolders)
 
-	// Wait for configuration to be ready and start sync client in background
+	// Handle sync info updates of all sync pairs
 	go func() {
-		<-ui.GetConfigReadyChannel()
-		log.Info("Configuration ready, starting sync client")
-
-		// Check if config is valid
-		if !c.IsConfigLoaded() {
-			log.Error("Configuration not loaded properly")
-			return
+		for syncInfo := range syncInfoChannel {
+			log.Info("Sync update: %s - %s", syncInfo.Filename, syncInfo.SyncStatus.String())
+			ui.AddSyncInfoToFyneTable(syncInfo)
 		}
+	}()
 
-		config := c.GetConfig()
-		if config.WorkingDirectory == "" || config.BucketName == "" {
-			log.Error("Invalid configuration: missing required fields")
-			return
-		}
+	// Wait for configuration to be ready and start sync clients in background.
+	// Every save of the configuration starts the sync pairs added since.
+	go func() {
+		for range ui.GetConfigReadyChannel() {
+			log.Info("Configuration ready, starting sync client")
 
-		// Start sync client only after config is ready
-		go sync.StartSyncClient(syncInfoChannel)
+			// Check if config is valid
+			if !c.IsConfigLoaded() {
+				log.Error("Configuration not loaded properly")
+				continue
+			}
 
-		// Handle sync info updates
-		for syncInfo := range syncInfoChannel {
-			log.Info("Sync update: %s - %s", syncInfo.Filename, syncInfo.SyncStatus.String())
-			ui.AddSyncInfoToFyneTable(syncInfo)
+			if err := c.ValidateConfig(c.GetConfig()); err != nil {
+				log.Error(fmt.Sprintf("Invalid configuration: %v", err))
+				continue
+			}
+
+			sync.StartSyncClient(syncInfoChannel)
 		}
 	}()
 
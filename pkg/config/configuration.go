@@ -2,18 +2,171 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/planetsp/k-drive/pkg/logging"
 )
 
+const (
+	ProviderAwsS3 = "aws s3"
+	ProviderLocal = "local"
+)
+
+// How to resolve a file that changed both locally and in the cloud since the
+// last sync.
+const (
+	ConflictNewestWins = "newest wins"
+	ConflictLocalWins  = "local wins"
+	ConflictCloudWins  = "cloud wins"
+	ConflictKeepBoth   = "keep both"
+)
+
+// DefaultIgnorePatterns keeps operating system and editor clutter out of the
+// cloud. More patterns go in IgnorePatterns or in a .kdriveignore file.
+var DefaultIgnorePatterns = []string{
+	".DS_Store",
+	"._*",
+	"Thumbs.db",
+	"desktop.ini",
+	"*.swp",
+	"*.swx",
+	"*~",
+	".~lock.*#",
+	"~$*",
+}
+
+// Server-side encryption S3 applies to uploaded objects.
+const (
+	SSES3  = "SSE-S3"
+	SSEKMS = "SSE-KMS"
+	SSEC   = "SSE-C"
+)
+
+// StorageClasses are the S3 storage classes objects can be uploaded with.
+// GLACIER and DEEP_ARCHIVE are left out, objects in them cannot be
+// downloaded until they are restored.
+var StorageClasses = []string{
+	"STANDARD",
+	"INTELLIGENT_TIERING",
+	"STANDARD_IA",
+	"ONEZONE_IA",
+	"GLACIER_IR",
+	"REDUCED_REDUNDANCY",
+}
+
+// StorageClassRule uploads the files matching Pattern, written like a line
+// of .gitignore, with StorageClass. Later rules take precedence, files no
+// rule matches get the StorageClass of the sync pair.
+type StorageClassRule struct {
+	Pattern      string `json:"pattern"`
+	StorageClass string `json:"storageClass"`
+}
+
+// Which way a sync pair copies changes.
+const (
+	DirectionTwoWay       = "two-way"
+	DirectionUploadOnly   = "upload only"
+	DirectionDownloadOnly = "download only"
+)
+
+const (
+	configFilename  = "conf.json"
+	stateFilename   = "kdrive-state.json"
+	journalFilename = "kdrive-transfers.json"
+	queueFilename   = "kdrive-queue.json"
+	keyFilename     = "kdrive-passphrase"
+)
+
+// SyncPair is one local folder and the place in the cloud it is synced with.
+type SyncPair struct {
+	// ID tells the state files of the pairs apart. The first pair has none
+	// so it keeps using the files from before there were several pairs.
+	ID                    string             `json:"id,omitempty"`
+	Name                  string             `json:"name,omitempty"`
+	WorkingDirectory      string             `json:"workingDirectory"`
+	CloudProvider         string             `json:"cloudProvider"`
+	BucketName            string             `json:"bucketName"`
+	RemotePrefix          string             `json:"remotePrefix,omitempty"`
+	EndpointURL           string             `json:"endpointUrl,omitempty"`
+	Region                string             `json:"region,omitempty"`
+	UsePathStyle          bool               `json:"usePathStyle,omitempty"`
+	InsecureSkipTLSVerify bool               `json:"insecureSkipTlsVerify,omitempty"`
+	ServerSideEncryption  string             `json:"serverSideEncryption,omitempty"`
+	KMSKeyID              string             `json:"kmsKeyId,omitempty"`
+	SSECustomerKeyFile    string             `json:"sseCustomerKeyFile,omitempty"`
+	StorageClass          string             `json:"storageClass,omitempty"`
+	StorageClassRules     []StorageClassRule `json:"storageClassRules,omitempty"`
+	LocalBackendDirectory string             `json:"localBackendDirectory,omitempty"`
+	ConflictPolicy        string             `json:"conflictPolicy,omitempty"`
+	SyncDirection         string             `json:"syncDirection,omitempty"`
+	PinnedPaths           []string           `json:"pinnedPaths,omitempty"`
+	IgnorePatterns        []string           `json:"ignorePatterns"`
+	SelectedFolders       []string           `json:"selectedFolders"`
+	// Encrypt files before they leave this computer. The passphrase is not
+	// part of the configuration, see SavePassphrase.
+	Encrypt          bool `json:"encrypt,omitempty"`
+	EncryptFilenames bool `json:"encryptFilenames,omitempty"`
+}
+
+// DisplayName is how the pair is shown in the UI and in log messages.
+func (pair *SyncPair) DisplayName() string {
+	if pair.Name != "" {
+		return pair.Name
+	}
+	return filepath.Base(filepath.Clean(pair.WorkingDirectory))
+}
+
 type Configuration struct {
-	AppName                        string        `json:"appName"`
-	WorkingDirectory               string        `json:"workingDirectory"`
-	CloudProvider                  string        `json:"cloudProvider"`
-	BucketName                     string        `json:"bucketName"`
+	AppName string `json:"appName"`
+	// The first sync pair is stored at the top level, as in configuration
+	// files from before there were several pairs.
+	SyncPair
 	LocalDirectoryPollingFrequency time.Duration `json:"localDirectoryPollingFrequency"`
+	CloudListingInterval           time.Duration `json:"cloudListingInterval,omitempty"` // seconds a full listing of more than 1000 objects is reused
+	MultipartPartSizeMB            int64         `json:"multipartPartSizeMB,omitempty"`
+	MultipartConcurrency           int           `json:"multipartConcurrency,omitempty"`
+	TransferWorkers                int           `json:"transferWorkers,omitempty"`
+	SyncPairs                      []SyncPair    `json:"syncPairs,omitempty"`
+}
+
+// Pairs returns every sync pair, the first one included.
+func (cfg *Configuration) Pairs() []*SyncPair {
+	pairs := []*SyncPair{&cfg.SyncPair}
+	for i := range cfg.SyncPairs {
+		pairs = append(pairs, &cfg.SyncPairs[i])
+	}
+	return pairs
+}
+
+// Pair returns the sync pair with the given ID, nil if there is none.
+func (cfg *Configuration) Pair(id string) *SyncPair {
+	for _, pair := range cfg.Pairs() {
+		if pair.ID == id {
+			return pair
+		}
+	}
+	return nil
+}
+
+// ForPair returns the configuration of a single sync pair, with the settings
+// shared by all pairs taken from cfg.
+func (cfg *Configuration) ForPair(pair *SyncPair) *Configuration {
+	single := *cfg
+	single.SyncPair = *pair
+	single.SyncPairs = nil
+	return &single
+}
+
+// NewPairID returns an ID for a sync pair that is added.
+func NewPairID() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
 }
 
 var config *Configuration
@@ -25,7 +178,7 @@ func init() {
 }
 
 func LoadConfig() bool {
-	file, err := os.Open("conf.json")
+	file, err := os.Open(configFilename)
 	if err != nil {
 		logging.Info("Configuration file 'conf.json' not found")
 		return false
@@ -35,7 +188,7 @@ func LoadConfig() bool {
 	decoder := json.NewDecoder(file)
 	err = decoder.Decode(config)
 	if err != nil {
-		logging.Error("Failed to parse configuration file: %v", err)
+		logging.Error(fmt.Sprintf("Failed to parse configuration file: %v", err))
 		return false
 	}
 
@@ -46,7 +199,7 @@ func LoadConfig() bool {
 func SaveConfig(cfg *Configuration) error {
 	config = cfg
 
-	file, err := os.Create("conf.json")
+	file, err := os.Create(configFilename)
 	if err != nil {
 		return err
 	}
@@ -72,12 +225,247 @@ func IsConfigLoaded() bool {
 	return configLoaded
 }
 
+// GetStateFilePath returns where the sync state index of a sync pair is kept,
+// next to the configuration file.
+func GetStateFilePath(pairID string) string {
+	return pairFilePath(stateFilename, pairID)
+}
+
+// GetJournalFilePath returns where unfinished transfers of a sync pair are
+// recorded so they can be resumed after a restart.
+func GetJournalFilePath(pairID string) string {
+	return pairFilePath(journalFilename, pairID)
+}
+
+// GetQueueFilePath returns where local changes made while offline are kept.
+func GetQueueFilePath(pairID string) string {
+	return pairFilePath(queueFilename, pairID)
+}
+
+// LoadPassphrase returns the encryption passphrase of a sync pair, "" if none
+// was saved. A passphrase saved next to the configuration file by earlier
+// versions is moved to where SavePassphrase keeps it.
+func LoadPassphrase(pairID string) (string, error) {
+	path, err := passphrasePath(pairID)
+	if err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		legacyPath := pairFilePath(keyFilename, pairID)
+		data, err = ioutil.ReadFile(legacyPath)
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		if err == nil {
+			passphrase := strings.TrimRight(string(data), "\r\n")
+			if err := SavePassphrase(pairID, passphrase); err != nil {
+				logging.Error(fmt.Sprintf("Failed to move the passphrase out of %s: %v", legacyPath, err))
+			}
+			return passphrase, nil
+		}
+	}
+	return strings.TrimRight(string(data), "\r\n"), err
+}
+
+// SavePassphrase stores the encryption passphrase of a sync pair in a file
+// only the current user can read, in the configuration folder of the user
+// rather than next to the configuration file, which may be shared or checked
+// in.
+func SavePassphrase(pairID string, passphrase string) error {
+	path, err := passphrasePath(pairID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, []byte(passphrase), 0600); err != nil {
+		return err
+	}
+	// WriteFile keeps the permissions of an existing file.
+	if err := os.Chmod(path, 0600); err != nil {
+		return err
+	}
+	if err := os.Remove(pairFilePath(keyFilename, pairID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func passphrasePath(pairID string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("no folder to keep the passphrase in: %w", err)
+	}
+	return filepath.Join(dir, "k-drive", filepath.Base(pairFilePath(keyFilename, pairID))), nil
+}
+
+func pairFilePath(filename string, pairID string) string {
+	if pairID != "" {
+		ext := filepath.Ext(filename)
+		filename = strings.TrimSuffix(filename, ext) + "-" + pairID + ext
+	}
+	return filepath.Join(filepath.Dir(configFilename), filename)
+}
+
 func CreateDefaultConfig() *Configuration {
 	return &Configuration{
 		AppName:                        "K-Drive",
-		WorkingDirectory:               "",
-		CloudProvider:                  "aws s3",
-		BucketName:                     "",
+		SyncPair:                       NewSyncPair(),
 		LocalDirectoryPollingFrequency: 3,
+		CloudListingInterval:           300,
+		MultipartPartSizeMB:            16,
+		MultipartConcurrency:           4,
+		TransferWorkers:                4,
+	}
+}
+
+// NewSyncPair returns a sync pair with the default settings.
+func NewSyncPair() SyncPair {
+	return SyncPair{
+		CloudProvider:  ProviderAwsS3,
+		ConflictPolicy: ConflictKeepBoth,
+		IgnorePatterns: append([]string{}, DefaultIgnorePatterns...),
+	}
+}
+
+// ValidateConfig checks that cfg holds everything needed to start syncing
+// every sync pair with its configured cloud provider.
+func ValidateConfig(cfg *Configuration) error {
+	ids := map[string]bool{}
+	var directories []string
+	for _, pair := range cfg.Pairs() {
+		if err := validatePair(pair); err != nil {
+			if len(cfg.SyncPairs) > 0 {
+				return fmt.Errorf("sync pair %s: %w", pair.DisplayName(), err)
+			}
+			return err
+		}
+		if ids[pair.ID] {
+			return fmt.Errorf("sync pair ID %q is used twice", pair.ID)
+		}
+		ids[pair.ID] = true
+		// Nested working directories would sync the same files twice.
+		for _, other := range directories {
+			if overlaps(pair.WorkingDirectory, other) {
+				return fmt.Errorf("working directory %s overlaps with another sync pair", pair.WorkingDirectory)
+			}
+		}
+		directories = append(directories, pair.WorkingDirectory)
+	}
+	if cfg.MultipartPartSizeMB != 0 && cfg.MultipartPartSizeMB < 5 {
+		return fmt.Errorf("multipart part size must be at least 5 MB")
+	}
+	if cfg.TransferWorkers < 0 {
+		return fmt.Errorf("number of transfer workers must not be negative")
+	}
+	if cfg.MultipartConcurrency < 0 {
+		return fmt.Errorf("multipart concurrency must not be negative")
+	}
+	if cfg.CloudListingInterval < 0 {
+		return fmt.Errorf("cloud listing interval must not be negative")
+	}
+	return nil
+}
+
+func validatePair(pair *SyncPair) error {
+	if pair.WorkingDirectory == "" {
+		return fmt.Errorf("missing working directory")
+	}
+	switch pair.CloudProvider {
+	case ProviderAwsS3, "":
+		if pair.BucketName == "" {
+			return fmt.Errorf("missing bucket name")
+		}
+		if pair.EndpointURL != "" {
+			endpoint, err := url.Parse(pair.EndpointURL)
+			if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+				return fmt.Errorf("endpoint URL %q must be an absolute http or https URL", pair.EndpointURL)
+			}
+		}
+		if err := validateS3Uploads(pair); err != nil {
+			return err
+		}
+	case ProviderLocal:
+		if pair.LocalBackendDirectory == "" {
+			return fmt.Errorf("missing local backend directory")
+		}
+		// The engine would otherwise sync its own uploads back down, or the
+		// other way around.
+		if overlaps(pair.LocalBackendDirectory, pair.WorkingDirectory) {
+			return fmt.Errorf("local backend directory must not be, contain or be inside the working directory")
+		}
+	default:
+		return fmt.Errorf("unsupported cloud provider %q", pair.CloudProvider)
+	}
+	switch pair.ConflictPolicy {
+	case "", ConflictNewestWins, ConflictLocalWins, ConflictCloudWins, ConflictKeepBoth:
+	default:
+		return fmt.Errorf("unsupported conflict policy %q", pair.ConflictPolicy)
+	}
+	switch pair.SyncDirection {
+	case "", DirectionTwoWay, DirectionUploadOnly, DirectionDownloadOnly:
+	default:
+		return fmt.Errorf("unsupported sync direction %q", pair.SyncDirection)
+	}
+	return nil
+}
+
+// validateS3Uploads checks the encryption and storage class settings S3
+// uploads are made with.
+func validateS3Uploads(pair *SyncPair) error {
+	switch pair.ServerSideEncryption {
+	case "", SSES3, SSEKMS:
+	case SSEC:
+		if pair.SSECustomerKeyFile == "" {
+			return fmt.Errorf("%s needs a customer key file", SSEC)
+		}
+	default:
+		return fmt.Errorf("unsupported server-side encryption %q", pair.ServerSideEncryption)
+	}
+	if pair.KMSKeyID != "" && pair.ServerSideEncryption != SSEKMS {
+		return fmt.Errorf("a KMS key is only used with %s", SSEKMS)
+	}
+	if pair.StorageClass != "" && !isStorageClass(pair.StorageClass) {
+		return fmt.Errorf("unsupported storage class %q, use one of %s", pair.StorageClass, strings.Join(StorageClasses, ", "))
+	}
+	for _, rule := range pair.StorageClassRules {
+		if strings.TrimSpace(rule.Pattern) == "" {
+			return fmt.Errorf("storage class rule without a pattern")
+		}
+		if !isStorageClass(rule.StorageClass) {
+			return fmt.Errorf("unsupported storage class %q for %s, use one of %s", rule.StorageClass, rule.Pattern, strings.Join(StorageClasses, ", "))
+		}
+	}
+	return nil
+}
+
+// overlaps reports whether two directories are the same or one is inside the
+// other.
+func overlaps(a string, b string) bool {
+	a, errA := filepath.Abs(a)
+	b, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	a, b = withSeparator(a), withSeparator(b)
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+func withSeparator(dir string) string {
+	if strings.HasSuffix(dir, string(filepath.Separator)) {
+		// The root directory
+		return dir
+	}
+	return dir + string(filepath.Separator)
+}
+
+func isStorageClass(class string) bool {
+	for _, known := range StorageClasses {
+		if class == known {
+			return true
+		}
 	}
+	return false
 }
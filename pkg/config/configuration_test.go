@@ -0,0 +1,131 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func localPair(workingDirectory string, backendDirectory string) SyncPair {
+	pair := NewSyncPair()
+	pair.CloudProvider = ProviderLocal
+	pair.WorkingDirectory = workingDirectory
+	pair.LocalBackendDirectory = backendDirectory
+	return pair
+}
+
+func TestValidatePairRejectsNestedLocalBackend(t *testing.T) {
+	root := t.TempDir()
+	tests := []struct {
+		name      string
+		working   string
+		backend   string
+		wantError bool
+	}{
+		{"separate", filepath.Join(root, "work"), filepath.Join(root, "backend"), false},
+		{"same name prefix", filepath.Join(root, "work"), filepath.Join(root, "work-backend"), false},
+		{"same", filepath.Join(root, "work"), filepath.Join(root, "work"), true},
+		{"same unclean", filepath.Join(root, "work"), filepath.Join(root, "work") + "/./", true},
+		{"backend inside", filepath.Join(root, "work"), filepath.Join(root, "work", "backend"), true},
+		{"working inside", filepath.Join(root, "backend", "work"), filepath.Join(root, "backend"), true},
+		{"backend is root", filepath.Join(root, "work"), "/", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pair := localPair(test.working, test.backend)
+			err := validatePair(&pair)
+			if (err != nil) != test.wantError {
+				t.Fatalf("validatePair(%q, %q) = %v, want error %v", test.working, test.backend, err, test.wantError)
+			}
+		})
+	}
+}
+
+func TestValidateConfigRejectsOverlappingPairs(t *testing.T) {
+	root := t.TempDir()
+	cfg := CreateDefaultConfig()
+	cfg.SyncPair = localPair(filepath.Join(root, "a"), filepath.Join(root, "backend-a"))
+	second := localPair(filepath.Join(root, "a", "b"), filepath.Join(root, "backend-b"))
+	second.ID = "second"
+	cfg.SyncPairs = []SyncPair{second}
+	if err := ValidateConfig(cfg); err == nil || !strings.Contains(err.Error(), "overlaps") {
+		t.Fatalf("ValidateConfig() = %v, want overlap error", err)
+	}
+
+	cfg.SyncPairs[0].WorkingDirectory = filepath.Join(root, "ab")
+	if err := ValidateConfig(cfg); err != nil {
+		t.Fatalf("ValidateConfig() = %v, want no error", err)
+	}
+}
+
+func TestPassphraseIsKeptOutsideTheWorkingDirectory(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+
+	// Earlier versions kept the passphrase next to conf.json.
+	if err := ioutil.WriteFile("kdrive-passphrase-pair", []byte("old secret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	passphrase, err := LoadPassphrase("pair")
+	if err != nil || passphrase != "old secret" {
+		t.Fatalf("LoadPassphrase() = %q, %v", passphrase, err)
+	}
+	if _, err := os.Stat("kdrive-passphrase-pair"); !os.IsNotExist(err) {
+		t.Fatal("passphrase was left next to the configuration file")
+	}
+
+	if err := SavePassphrase("pair", "new secret"); err != nil {
+		t.Fatal(err)
+	}
+	path, err := passphrasePath("pair")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(path, home) {
+		t.Fatalf("passphrase is kept in %s, outside the configuration folder of the user", path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("passphrase file has permissions %v, want 0600", info.Mode().Perm())
+	}
+	if passphrase, err := LoadPassphrase("pair"); err != nil || passphrase != "new secret" {
+		t.Fatalf("LoadPassphrase() = %q, %v", passphrase, err)
+	}
+	if passphrase, err := LoadPassphrase("other"); err != nil || passphrase != "" {
+		t.Fatalf("LoadPassphrase() of a pair without one = %q, %v", passphrase, err)
+	}
+}
+
+func TestValidateS3UploadsRejectsArchiveClasses(t *testing.T) {
+	pair := NewSyncPair()
+	pair.StorageClass = "STANDARD_IA"
+	pair.StorageClassRules = []StorageClassRule{{Pattern: "archive/", StorageClass: "GLACIER_IR"}}
+	if err := validateS3Uploads(&pair); err != nil {
+		t.Fatalf("validateS3Uploads() = %v", err)
+	}
+	for _, class := range []string{"GLACIER", "DEEP_ARCHIVE", "standard"} {
+		pair.StorageClass = class
+		if err := validateS3Uploads(&pair); err == nil {
+			t.Errorf("storage class %s was accepted", class)
+		}
+		pair.StorageClass = ""
+		pair.StorageClassRules = []StorageClassRule{{Pattern: "archive/", StorageClass: class}}
+		if err := validateS3Uploads(&pair); err == nil {
+			t.Errorf("rule with storage class %s was accepted", class)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package encryption
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+// Names are encrypted one path segment at a time, so folders stay folders in
+// the cloud. The nonce is derived from the name itself: the same name always
+// encrypts the same way, which is what lets a key be looked up or deleted,
+// and only reveals which names are equal.
+
+// EncryptKey encrypts every segment of a '/' separated key.
+func (keys *Keys) EncryptKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		if segment != "" {
+			segments[i] = keys.encryptName(segment)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// DecryptKey reverses EncryptKey. It fails with ErrCorrupted for keys that
+// were not encrypted with these keys.
+func (keys *Keys) DecryptKey(key string) (string, error) {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		if segment == "" {
+			continue
+		}
+		name, err := keys.decryptName(segment)
+		if err != nil {
+			return "", err
+		}
+		segments[i] = name
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+func (keys *Keys) encryptName(name string) string {
+	mac := hmac.New(sha256.New, keys.names)
+	mac.Write([]byte(name))
+	nonce := mac.Sum(nil)[:nonceSize]
+	aead, err := newGCM(keys.names)
+	if err != nil {
+		// Only fails for invalid key sizes, which derived keys never have.
+		panic(err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(name), nil)
+	return base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+func (keys *Keys) decryptName(segment string) (string, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil || len(sealed) < nonceSize+tagSize {
+		return "", ErrCorrupted
+	}
+	aead, err := newGCM(keys.names)
+	if err != nil {
+		return "", err
+	}
+	name, err := aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", ErrCorrupted
+	}
+	return string(name), nil
+}
+
+// EncryptValue encrypts a metadata value. Unlike names, equal values encrypt
+// differently every time, so e.g. content hashes reveal nothing.
+func (keys *Keys) EncryptValue(value string) (string, error) {
+	aead, err := newGCM(keys.metadata)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptValue reverses EncryptValue.
+func (keys *Keys) DecryptValue(value string) (string, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(sealed) < nonceSize+tagSize {
+		return "", ErrCorrupted
+	}
+	aead, err := newGCM(keys.metadata)
+	if err != nil {
+		return "", err
+	}
+	plain, err := aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", ErrCorrupted
+	}
+	return string(plain), nil
+}
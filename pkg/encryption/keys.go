@@ -0,0 +1,85 @@
+// Package encryption encrypts file contents and names on the client, so the
+// cloud only ever stores ciphertext.
+package encryption
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// ErrWrongPassphrase is returned when a passphrase does not match the one the
+// stored data was encrypted with.
+var ErrWrongPassphrase = errors.New("wrong encryption passphrase")
+
+const (
+	keySize  = 32
+	saltSize = 16
+
+	// DefaultIterations is the PBKDF2 work factor for new key parameters.
+	DefaultIterations = 200000
+)
+
+// Params are the non-secret inputs needed to derive the keys from the
+// passphrase. They are stored next to the encrypted data so every computer
+// syncing it derives the same keys.
+type Params struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Iterations int    `json:"iterations"`
+	// Check is derived from the master key and tells a wrong passphrase
+	// apart from corrupted data.
+	Check []byte `json:"check"`
+}
+
+// Keys are the keys derived from a passphrase.
+type Keys struct {
+	content  []byte // wraps the random key of each file
+	names    []byte // encrypts file and folder names
+	metadata []byte // encrypts object metadata
+	check    []byte
+}
+
+// NewParams derives keys from passphrase with a new random salt and returns
+// the parameters to store along with the data.
+func NewParams(passphrase string) (*Params, *Keys, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, err
+	}
+	params := &Params{Version: 1, Salt: salt, Iterations: DefaultIterations}
+	keys := deriveKeys(passphrase, params)
+	params.Check = keys.check
+	return params, keys, nil
+}
+
+// DeriveKeys derives the keys of passphrase from stored parameters.
+func DeriveKeys(passphrase string, params *Params) (*Keys, error) {
+	if params.Version != 1 {
+		return nil, errors.New("unsupported encryption version")
+	}
+	keys := deriveKeys(passphrase, params)
+	if !hmac.Equal(keys.check, params.Check) {
+		return nil, ErrWrongPassphrase
+	}
+	return keys, nil
+}
+
+func deriveKeys(passphrase string, params *Params) *Keys {
+	master := pbkdf2.Key([]byte(passphrase), params.Salt, params.Iterations, keySize, sha256.New)
+	return &Keys{
+		content:  subkey(master, "k-drive content"),
+		names:    subkey(master, "k-drive names"),
+		metadata: subkey(master, "k-drive metadata"),
+		check:    subkey(master, "k-drive check"),
+	}
+}
+
+func subkey(master []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
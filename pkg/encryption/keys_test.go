@@ -0,0 +1,36 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestDeriveKeys(t *testing.T) {
+	params, keys, err := NewParams("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(params.Salt) != saltSize || params.Iterations != DefaultIterations {
+		t.Fatalf("NewParams returned %+v", params)
+	}
+	derived, err := DeriveKeys("correct horse battery staple", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(derived.content, keys.content) || !bytes.Equal(derived.names, keys.names) {
+		t.Fatal("derived keys differ from the new ones")
+	}
+	if _, err := DeriveKeys("wrong", params); err != ErrWrongPassphrase {
+		t.Fatalf("DeriveKeys with a wrong passphrase = %v, want ErrWrongPassphrase", err)
+	}
+}
+
+func TestDeriveKeysIsStable(t *testing.T) {
+	// Data encrypted by earlier versions has to stay readable.
+	check, _ := hex.DecodeString("abf9d108fdac82bb0fc72442349a4b7db91330cb5d5ed8aa33c3f9a81dfb5d13")
+	params := &Params{Version: 1, Salt: []byte("0123456789abcdef"), Iterations: 1000, Check: check}
+	if _, err := DeriveKeys("correct horse battery staple", params); err != nil {
+		t.Fatalf("DeriveKeys() = %v, keys changed", err)
+	}
+}
@@ -0,0 +1,295 @@
+package encryption
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted contents start with a header holding the random key of the file,
+// wrapped with the content key, followed by the content split into chunks
+// that are sealed one by one. The nonce of a chunk is its index and whether
+// it is the last one, so chunks cannot be reordered, dropped or cut off
+// unnoticed.
+const (
+	// ChunkSize is how much plaintext goes into one chunk.
+	ChunkSize = 64 * 1024
+
+	tagSize       = 16
+	nonceSize     = 12
+	wrappedSize   = nonceSize + keySize + tagSize
+	cipherChunk   = ChunkSize + tagSize
+	contentMagic  = "KDE1"
+	HeaderSize    = len(contentMagic) + wrappedSize
+	finalChunkBit = 1
+)
+
+// ErrCorrupted is returned when encrypted content fails to authenticate.
+var ErrCorrupted = errors.New("encrypted content is corrupted or was not written by k-drive")
+
+// EncryptedSize is the size of the encryption of size bytes.
+func EncryptedSize(size int64) int64 {
+	chunks := (size + ChunkSize - 1) / ChunkSize
+	if chunks == 0 {
+		// Even empty content has a final chunk, so truncation is detected.
+		chunks = 1
+	}
+	return int64(HeaderSize) + chunks*tagSize + size
+}
+
+// PlaintextSize is the size of the content whose encryption is size bytes,
+// -1 if no content encrypts to that size.
+func PlaintextSize(size int64) int64 {
+	size -= int64(HeaderSize)
+	if size < tagSize {
+		return -1
+	}
+	full, rest := size/cipherChunk, size%cipherChunk
+	if rest == 0 {
+		return full * ChunkSize
+	}
+	if rest < tagSize {
+		return -1
+	}
+	return full*ChunkSize + rest - tagSize
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(index int64, final bool) []byte {
+	nonce := make([]byte, nonceSize)
+	binary.BigEndian.PutUint64(nonce, uint64(index))
+	if final {
+		nonce[nonceSize-1] = finalChunkBit
+	}
+	return nonce
+}
+
+// Encrypt returns a reader of the encryption of plaintext under a new random
+// file key.
+func (keys *Keys) Encrypt(plaintext io.Reader) (io.Reader, error) {
+	fileKey := make([]byte, keySize)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, err
+	}
+	wrapper, err := newGCM(keys.content)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header := append([]byte(contentMagic), nonce...)
+	header = wrapper.Seal(header, nonce, fileKey, []byte(contentMagic))
+
+	aead, err := newGCM(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptingReader{
+		src:  bufio.NewReaderSize(plaintext, ChunkSize),
+		aead: aead,
+		out:  header,
+		buf:  make([]byte, ChunkSize),
+	}, nil
+}
+
+type encryptingReader struct {
+	src    *bufio.Reader
+	aead   cipher.AEAD
+	out    []byte // sealed data not read yet
+	buf    []byte
+	sealed []byte
+	index  int64
+	done   bool
+	err    error
+}
+
+func (r *encryptingReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.sealChunk()
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *encryptingReader) sealChunk() {
+	n, err := io.ReadFull(r.src, r.buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		r.err = err
+		return
+	}
+	final := n < ChunkSize
+	if !final {
+		// A full chunk is the last one if nothing follows it.
+		if _, err := r.src.Peek(1); err == io.EOF {
+			final = true
+		} else if err != nil {
+			r.err = err
+			return
+		}
+	}
+	r.sealed = r.aead.Seal(r.sealed[:0], chunkNonce(r.index, final), r.buf[:n], nil)
+	r.out = r.sealed
+	r.index++
+	r.done = final
+}
+
+// openFileKey unwraps the key of a file from its header.
+func (keys *Keys) openFileKey(header []byte) (cipher.AEAD, error) {
+	if len(header) != HeaderSize || string(header[:len(contentMagic)]) != contentMagic {
+		return nil, ErrCorrupted
+	}
+	wrapper, err := newGCM(keys.content)
+	if err != nil {
+		return nil, err
+	}
+	wrapped := header[len(contentMagic):]
+	fileKey, err := wrapper.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], []byte(contentMagic))
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+	return newGCM(fileKey)
+}
+
+// Decrypt returns a reader of the content encrypted in ciphertext. Reading
+// fails with ErrCorrupted as soon as something does not authenticate,
+// including content that ends early.
+func (keys *Keys) Decrypt(ciphertext io.Reader) (io.Reader, error) {
+	header := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(ciphertext, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrCorrupted
+		}
+		return nil, err
+	}
+	aead, err := keys.openFileKey(header)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingReader{
+		src:  bufio.NewReaderSize(ciphertext, cipherChunk),
+		aead: aead,
+		buf:  make([]byte, cipherChunk),
+	}, nil
+}
+
+type decryptingReader struct {
+	src   *bufio.Reader
+	aead  cipher.AEAD
+	out   []byte
+	buf   []byte
+	plain []byte
+	index int64
+	done  bool
+	err   error
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.openChunk()
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *decryptingReader) openChunk() {
+	n, err := io.ReadFull(r.src, r.buf)
+	if err == io.EOF || (err == io.ErrUnexpectedEOF && n < tagSize) {
+		r.err = ErrCorrupted
+		return
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		r.err = err
+		return
+	}
+	final := n < cipherChunk
+	if !final {
+		if _, err := r.src.Peek(1); err == io.EOF {
+			final = true
+		} else if err != nil {
+			r.err = err
+			return
+		}
+	}
+	r.plain, err = r.aead.Open(r.plain[:0], chunkNonce(r.index, final), r.buf[:n], nil)
+	if err != nil {
+		r.err = ErrCorrupted
+		return
+	}
+	r.out = r.plain
+	r.index++
+	r.done = final
+}
+
+// ChunkRange returns the byte range of the encrypted content holding the
+// plaintext range of length bytes at offset, and the index of the first
+// chunk in it.
+func ChunkRange(offset int64, length int64) (cipherOffset int64, cipherLength int64, firstChunk int64) {
+	firstChunk = offset / ChunkSize
+	lastChunk := (offset + length - 1) / ChunkSize
+	cipherOffset = int64(HeaderSize) + firstChunk*cipherChunk
+	cipherLength = (lastChunk - firstChunk + 1) * cipherChunk
+	return cipherOffset, cipherLength, firstChunk
+}
+
+// DecryptRange decrypts chunks, read from the range ChunkRange returned, and
+// returns the plaintext range of length bytes at offset. header is the
+// beginning of the encrypted content.
+func (keys *Keys) DecryptRange(header []byte, chunks []byte, offset int64, length int64) ([]byte, error) {
+	aead, err := keys.openFileKey(header)
+	if err != nil {
+		return nil, err
+	}
+	_, _, index := ChunkRange(offset, length)
+	plaintext := make([]byte, 0, len(chunks))
+	for len(chunks) > 0 {
+		n := cipherChunk
+		if len(chunks) < n {
+			n = len(chunks)
+		}
+		// Without the total size it is unknown whether this is the last
+		// chunk, only one of the two nonces can authenticate it.
+		plain, err := aead.Open(plaintext[len(plaintext):], chunkNonce(index, false), chunks[:n], nil)
+		if err != nil {
+			plain, err = aead.Open(plaintext[len(plaintext):], chunkNonce(index, true), chunks[:n], nil)
+		}
+		if err != nil {
+			return nil, ErrCorrupted
+		}
+		plaintext = plaintext[:len(plaintext)+len(plain)]
+		chunks = chunks[n:]
+		index++
+	}
+	start := offset % ChunkSize
+	if start+length > int64(len(plaintext)) {
+		return nil, fmt.Errorf("encrypted range ends early: got %d of %d bytes", int64(len(plaintext))-start, length)
+	}
+	return plaintext[start : start+length], nil
+}
@@ -0,0 +1,144 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"testing"
+)
+
+func testKeys(t *testing.T) *Keys {
+	t.Helper()
+	_, keys, err := NewParams("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func encrypt(t *testing.T, keys *Keys, plaintext []byte) []byte {
+	t.Helper()
+	reader, err := keys.Encrypt(bytes.NewReader(plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ciphertext
+}
+
+func decrypt(keys *Keys, ciphertext []byte) ([]byte, error) {
+	reader, err := keys.Decrypt(bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	keys := testKeys(t)
+	sizes := []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3*ChunkSize + 100}
+	for _, size := range sizes {
+		plaintext := make([]byte, size)
+		if _, err := rand.Read(plaintext); err != nil {
+			t.Fatal(err)
+		}
+		ciphertext := encrypt(t, keys, plaintext)
+		if int64(len(ciphertext)) != EncryptedSize(int64(size)) {
+			t.Errorf("encryption of %d bytes is %d bytes, EncryptedSize says %d", size, len(ciphertext), EncryptedSize(int64(size)))
+		}
+		if got := PlaintextSize(int64(len(ciphertext))); got != int64(size) {
+			t.Errorf("PlaintextSize(%d) = %d, want %d", len(ciphertext), got, size)
+		}
+		decrypted, err := decrypt(keys, ciphertext)
+		if err != nil {
+			t.Fatalf("decrypting %d bytes: %v", size, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Fatalf("decryption of %d bytes differs from the plaintext", size)
+		}
+	}
+}
+
+func TestPlaintextSizeOfImpossibleSizes(t *testing.T) {
+	for _, size := range []int64{0, int64(HeaderSize), int64(HeaderSize) + tagSize - 1, int64(HeaderSize) + cipherChunk + 1} {
+		if got := PlaintextSize(size); got != -1 {
+			t.Errorf("PlaintextSize(%d) = %d, want -1", size, got)
+		}
+	}
+}
+
+func TestDecryptDetectsTampering(t *testing.T) {
+	keys := testKeys(t)
+	ciphertext := encrypt(t, keys, bytes.Repeat([]byte("k"), 2*ChunkSize+10))
+
+	flipped := append([]byte{}, ciphertext...)
+	flipped[HeaderSize+ChunkSize/2] ^= 1
+	truncated := ciphertext[:HeaderSize+cipherChunk]
+	swapped := append([]byte{}, ciphertext[:HeaderSize]...)
+	swapped = append(swapped, ciphertext[HeaderSize+cipherChunk:HeaderSize+2*cipherChunk]...)
+	swapped = append(swapped, ciphertext[HeaderSize:HeaderSize+cipherChunk]...)
+	swapped = append(swapped, ciphertext[HeaderSize+2*cipherChunk:]...)
+
+	for name, tampered := range map[string][]byte{"flipped": flipped, "truncated": truncated, "swapped": swapped} {
+		if _, err := decrypt(keys, tampered); err != ErrCorrupted {
+			t.Errorf("decrypting %s content = %v, want ErrCorrupted", name, err)
+		}
+	}
+	if _, err := decrypt(testKeys(t), ciphertext); err == nil {
+		t.Error("content decrypted with other keys")
+	}
+}
+
+func TestDecryptRange(t *testing.T) {
+	keys := testKeys(t)
+	plaintext := make([]byte, 3*ChunkSize+100)
+	if _, err := rand.Read(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := encrypt(t, keys, plaintext)
+
+	ranges := []struct{ offset, length int64 }{
+		{0, 1},
+		{0, ChunkSize},
+		{ChunkSize - 1, 2},
+		{ChunkSize + 10, ChunkSize},
+		{3 * ChunkSize, 100},
+		{0, int64(len(plaintext))},
+	}
+	for _, r := range ranges {
+		cipherOffset, cipherLength, _ := ChunkRange(r.offset, r.length)
+		end := cipherOffset + cipherLength
+		if end > int64(len(ciphertext)) {
+			end = int64(len(ciphertext))
+		}
+		got, err := keys.DecryptRange(ciphertext[:HeaderSize], ciphertext[cipherOffset:end], r.offset, r.length)
+		if err != nil {
+			t.Fatalf("DecryptRange(%d, %d) = %v", r.offset, r.length, err)
+		}
+		if !bytes.Equal(got, plaintext[r.offset:r.offset+r.length]) {
+			t.Fatalf("DecryptRange(%d, %d) returned the wrong bytes", r.offset, r.length)
+		}
+	}
+}
+
+func TestChunkRange(t *testing.T) {
+	tests := []struct {
+		offset, length                         int64
+		cipherOffset, cipherLength, firstChunk int64
+	}{
+		{0, 1, int64(HeaderSize), cipherChunk, 0},
+		{ChunkSize - 1, 2, int64(HeaderSize), 2 * cipherChunk, 0},
+		{ChunkSize, ChunkSize, int64(HeaderSize) + cipherChunk, cipherChunk, 1},
+		{2*ChunkSize + 5, 10, int64(HeaderSize) + 2*cipherChunk, cipherChunk, 2},
+	}
+	for _, test := range tests {
+		cipherOffset, cipherLength, firstChunk := ChunkRange(test.offset, test.length)
+		if cipherOffset != test.cipherOffset || cipherLength != test.cipherLength || firstChunk != test.firstChunk {
+			t.Errorf("ChunkRange(%d, %d) = %d, %d, %d, want %d, %d, %d", test.offset, test.length,
+				cipherOffset, cipherLength, firstChunk, test.cipherOffset, test.cipherLength, test.firstChunk)
+		}
+	}
+}
@@ -0,0 +1,679 @@
+// Package fakes3 provides an in-process stand-in for Amazon S3 so the sync
+// engine can be exercised end to end without network access or credentials.
+//
+// Only the subset of the REST API used by k-drive is implemented and the
+// server expects path-style addressing. Point a client at it with
+//
+//	server := fakes3.NewServer("my-bucket")
+//	defer server.Close()
+//	client := storage.CreateS3Client(server.S3Options)
+package fakes3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const defaultMaxKeys = 1000
+
+type object struct {
+	data         []byte
+	etag         string
+	lastModified time.Time
+	metadata     map[string]string
+	storageClass string
+}
+
+type part struct {
+	data         []byte
+	etag         string
+	lastModified time.Time
+}
+
+type multipartUpload struct {
+	bucket       string
+	key          string
+	initiated    time.Time
+	metadata     map[string]string
+	storageClass string
+	parts        map[int]*part
+}
+
+type Server struct {
+	URL string
+
+	httpServer   *httptest.Server
+	mu           sync.Mutex
+	buckets      map[string]map[string]*object
+	uploads      map[string]*multipartUpload
+	nextUploadID int
+	requests     map[string]int
+}
+
+// NewServer starts a fake S3 server holding the given, initially empty, buckets.
+func NewServer(bucketNames ...string) *Server {
+	server := &Server{
+		buckets:  map[string]map[string]*object{},
+		uploads:  map[string]*multipartUpload{},
+		requests: map[string]int{},
+	}
+	for _, name := range bucketNames {
+		server.CreateBucket(name)
+	}
+	server.httpServer = httptest.NewServer(http.HandlerFunc(server.handle))
+	server.URL = server.httpServer.URL
+	return server
+}
+
+func (server *Server) Close() {
+	server.httpServer.Close()
+}
+
+// S3Options configures an S3 client to talk to this server. It has the
+// signature expected by s3.NewFromConfig and storage.CreateS3Client.
+func (server *Server) S3Options(o *s3.Options) {
+	o.EndpointResolver = s3.EndpointResolverFromURL(server.URL)
+	o.UsePathStyle = true
+	o.Region = "us-east-1"
+	o.Credentials = aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "fake", SecretAccessKey: "fake", Source: "fakes3"}, nil
+	})
+}
+
+func (server *Server) CreateBucket(name string) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if _, ok := server.buckets[name]; !ok {
+		server.buckets[name] = map[string]*object{}
+	}
+}
+
+// PutObject stores data directly, bypassing HTTP. Useful for seeding a bucket
+// with "remote" changes.
+func (server *Server) PutObject(bucket, key string, data []byte, metadata map[string]string) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if _, ok := server.buckets[bucket]; !ok {
+		server.buckets[bucket] = map[string]*object{}
+	}
+	server.buckets[bucket][key] = newObject(data, metadata)
+}
+
+// GetObject returns the stored content of key, bypassing HTTP.
+func (server *Server) GetObject(bucket, key string) ([]byte, bool) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	obj, ok := server.buckets[bucket][key]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), obj.data...), true
+}
+
+func (server *Server) DeleteObject(bucket, key string) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	delete(server.buckets[bucket], key)
+}
+
+// Keys returns the sorted keys currently stored in bucket.
+func (server *Server) Keys(bucket string) []string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	keys := make([]string, 0, len(server.buckets[bucket]))
+	for key := range server.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// StorageClass returns the storage class key was uploaded with, bypassing
+// HTTP.
+func (server *Server) StorageClass(bucket, key string) (string, bool) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	obj, ok := server.buckets[bucket][key]
+	if !ok {
+		return "", false
+	}
+	return obj.storageClass, true
+}
+
+// MultipartUploads returns the number of multipart uploads that have been
+// started but neither completed nor aborted.
+func (server *Server) MultipartUploads() int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return len(server.uploads)
+}
+
+// RequestCount returns how many requests of the given S3 operation, e.g.
+// "ListObjectsV2" or "UploadPart", the server has handled.
+func (server *Server) RequestCount(operation string) int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.requests[operation]
+}
+
+func newObject(data []byte, metadata map[string]string) *object {
+	sum := md5.Sum(data)
+	return &object{
+		data:         data,
+		etag:         hex.EncodeToString(sum[:]),
+		lastModified: time.Now().UTC().Truncate(time.Second),
+		metadata:     metadata,
+		storageClass: "STANDARD",
+	}
+}
+
+func (server *Server) handle(w http.ResponseWriter, r *http.Request) {
+	bucket, key := splitPath(r.URL.Path)
+	query := r.URL.Query()
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	objects, ok := server.buckets[bucket]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodGet && query.Has("uploads"):
+		server.count("ListMultipartUploads")
+		server.listMultipartUploads(w, bucket, query.Get("prefix"))
+	case key == "" && r.Method == http.MethodGet:
+		server.count("ListObjectsV2")
+		server.listObjects(w, r, bucket, objects)
+	case key == "" && r.Method == http.MethodHead:
+		server.count("HeadBucket")
+		w.WriteHeader(http.StatusOK)
+	case key == "":
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", "Bucket operation not supported")
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		server.count("CreateMultipartUpload")
+		server.createMultipartUpload(w, r, bucket, key)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		server.count("UploadPart")
+		server.uploadPart(w, r, query)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		server.count("CompleteMultipartUpload")
+		server.completeMultipartUpload(w, r, objects, bucket, key, query.Get("uploadId"))
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		server.count("AbortMultipartUpload")
+		server.abortMultipartUpload(w, r, query.Get("uploadId"))
+	case r.Method == http.MethodGet && query.Has("uploadId"):
+		server.count("ListParts")
+		server.listParts(w, r, bucket, key, query.Get("uploadId"))
+	case r.Method == http.MethodPut:
+		server.count("PutObject")
+		server.putObject(w, r, objects, key)
+	case r.Method == http.MethodGet:
+		server.count("GetObject")
+		server.getObject(w, r, objects, key)
+	case r.Method == http.MethodHead:
+		server.count("HeadObject")
+		server.getObject(w, r, objects, key)
+	case r.Method == http.MethodDelete:
+		server.count("DeleteObject")
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", "Object operation not supported")
+	}
+}
+
+func (server *Server) count(operation string) {
+	server.requests[operation]++
+}
+
+func splitPath(path string) (string, string) {
+	path = strings.TrimPrefix(path, "/")
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	KeyCount              int            `xml:"KeyCount"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	Contents              []objectEntry  `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type objectEntry struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+func (server *Server) listObjects(w http.ResponseWriter, r *http.Request, bucket string, objects map[string]*object) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	maxKeys := defaultMaxKeys
+	if value := query.Get("max-keys"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			writeError(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid max-keys")
+			return
+		}
+		maxKeys = parsed
+	}
+	// Continuation tokens are simply the last entry returned, a key or a
+	// common prefix, which is enough for a fake and keeps listings stable
+	// while objects change. Entries sort the same way as the keys they stand
+	// for, so everything up to and including the token was listed.
+	startAfter := query.Get("start-after")
+	token := query.Get("continuation-token")
+
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		if strings.HasPrefix(key, prefix) && key > startAfter && listEntry(key, prefix, delimiter) > token {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := listBucketResult{
+		Name:              bucket,
+		Prefix:            prefix,
+		Delimiter:         delimiter,
+		MaxKeys:           maxKeys,
+		ContinuationToken: token,
+		StartAfter:        startAfter,
+	}
+	for _, key := range keys {
+		entry := listEntry(key, prefix, delimiter)
+		if entry == result.NextContinuationToken {
+			// Another key rolled up into the common prefix just listed
+			continue
+		}
+		if result.KeyCount >= maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		if entry != key {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: entry})
+		} else {
+			obj := objects[key]
+			result.Contents = append(result.Contents, objectEntry{
+				Key:          key,
+				LastModified: obj.lastModified.Format(time.RFC3339),
+				ETag:         quote(obj.etag),
+				Size:         int64(len(obj.data)),
+				StorageClass: obj.storageClass,
+			})
+		}
+		result.KeyCount++
+		result.NextContinuationToken = entry
+	}
+	if !result.IsTruncated {
+		result.NextContinuationToken = ""
+	}
+	writeXML(w, result)
+}
+
+// listEntry is what key shows up as in a listing: the common prefix it rolls
+// up into when delimiter occurs after prefix, or the key itself.
+func listEntry(key string, prefix string, delimiter string) string {
+	if delimiter != "" {
+		if index := strings.Index(key[len(prefix):], delimiter); index >= 0 {
+			return key[:len(prefix)+index+len(delimiter)]
+		}
+	}
+	return key
+}
+
+func (server *Server) putObject(w http.ResponseWriter, r *http.Request, objects map[string]*object, key string) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if _, exists := objects[key]; exists && r.Header.Get("If-None-Match") == "*" {
+		writeError(w, r, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+		return
+	}
+	obj := newObject(data, metadataFromHeader(r.Header))
+	obj.storageClass = storageClassFromHeader(r.Header)
+	objects[key] = obj
+	w.Header().Set("ETag", quote(obj.etag))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (server *Server) getObject(w http.ResponseWriter, r *http.Request, objects map[string]*object, key string) {
+	obj, ok := objects[key]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	if match := r.Header.Get("If-Match"); match != "" && strings.Trim(match, "\"") != obj.etag {
+		writeError(w, r, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+		return
+	}
+	header := w.Header()
+	for name, value := range obj.metadata {
+		header.Set("X-Amz-Meta-"+name, value)
+	}
+	header.Set("ETag", quote(obj.etag))
+	header.Set("Last-Modified", obj.lastModified.Format(http.TimeFormat))
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("Content-Type", "binary/octet-stream")
+
+	size := int64(len(obj.data))
+	start, end := int64(0), size-1
+	status := http.StatusOK
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		var ok bool
+		start, end, ok = parseRange(rangeHeader, size)
+		if !ok {
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			writeError(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+			return
+		}
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		status = http.StatusPartialContent
+	}
+	header.Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(obj.data[start : end+1])
+	}
+}
+
+// parseRange understands the single range forms S3 accepts:
+// "bytes=first-last", "bytes=first-" and "bytes=-suffixLength".
+func parseRange(value string, size int64) (int64, int64, bool) {
+	if !strings.HasPrefix(value, "bytes=") || strings.Contains(value, ",") {
+		return 0, 0, false
+	}
+	spec := strings.SplitN(strings.TrimPrefix(value, "bytes="), "-", 2)
+	if len(spec) != 2 {
+		return 0, 0, false
+	}
+	if spec[0] == "" {
+		suffix, err := strconv.ParseInt(spec[1], 10, 64)
+		if err != nil || suffix <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, size - 1, true
+	}
+	start, err := strconv.ParseInt(spec[0], 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if spec[1] != "" {
+		end, err = strconv.ParseInt(spec[1], 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, true
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+func (server *Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	server.nextUploadID++
+	uploadID := fmt.Sprintf("upload-%d", server.nextUploadID)
+	server.uploads[uploadID] = &multipartUpload{
+		bucket:       bucket,
+		key:          key,
+		initiated:    time.Now().UTC(),
+		metadata:     metadataFromHeader(r.Header),
+		storageClass: storageClassFromHeader(r.Header),
+		parts:        map[int]*part{},
+	}
+	writeXML(w, initiateMultipartUploadResult{Bucket: bucket, Key: key, UploadID: uploadID})
+}
+
+func (server *Server) uploadPart(w http.ResponseWriter, r *http.Request, query url.Values) {
+	upload, ok := server.uploads[query.Get("uploadId")]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+	partNumber, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > 10000 {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000")
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	sum := md5.Sum(data)
+	p := &part{data: data, etag: hex.EncodeToString(sum[:]), lastModified: time.Now().UTC()}
+	upload.parts[partNumber] = p
+	w.Header().Set("ETag", quote(p.etag))
+	w.WriteHeader(http.StatusOK)
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+	Bucket  string   `xml:"Bucket"`
+	Key     string   `xml:"Key"`
+	ETag    string   `xml:"ETag"`
+}
+
+func (server *Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, objects map[string]*object, bucket, key, uploadID string) {
+	upload, ok := server.uploads[uploadID]
+	if !ok || upload.key != key || upload.bucket != bucket {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+	var request completeMultipartUpload
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Parts) == 0 {
+		writeError(w, r, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed")
+		return
+	}
+
+	var data []byte
+	var digests []byte
+	previous := 0
+	for _, requested := range request.Parts {
+		p, ok := upload.parts[requested.PartNumber]
+		if !ok || p.etag != strings.Trim(requested.ETag, "\"") {
+			writeError(w, r, http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found.")
+			return
+		}
+		if requested.PartNumber <= previous {
+			writeError(w, r, http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order.")
+			return
+		}
+		previous = requested.PartNumber
+		data = append(data, p.data...)
+		digest, _ := hex.DecodeString(p.etag)
+		digests = append(digests, digest...)
+	}
+
+	obj := newObject(data, upload.metadata)
+	obj.storageClass = upload.storageClass
+	sum := md5.Sum(digests)
+	obj.etag = fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), len(request.Parts))
+	objects[key] = obj
+	delete(server.uploads, uploadID)
+	writeXML(w, completeMultipartUploadResult{Bucket: bucket, Key: key, ETag: quote(obj.etag)})
+}
+
+func (server *Server) abortMultipartUpload(w http.ResponseWriter, r *http.Request, uploadID string) {
+	if _, ok := server.uploads[uploadID]; !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+	delete(server.uploads, uploadID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type uploadEntry struct {
+	Key       string `xml:"Key"`
+	UploadID  string `xml:"UploadId"`
+	Initiated string `xml:"Initiated"`
+}
+
+type listMultipartUploadsResult struct {
+	XMLName     xml.Name      `xml:"ListMultipartUploadsResult"`
+	Bucket      string        `xml:"Bucket"`
+	IsTruncated bool          `xml:"IsTruncated"`
+	Uploads     []uploadEntry `xml:"Upload"`
+}
+
+func (server *Server) listMultipartUploads(w http.ResponseWriter, bucket string, prefix string) {
+	result := listMultipartUploadsResult{Bucket: bucket}
+	ids := make([]string, 0, len(server.uploads))
+	for id := range server.uploads {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		upload := server.uploads[id]
+		if upload.bucket != bucket || !strings.HasPrefix(upload.key, prefix) {
+			continue
+		}
+		result.Uploads = append(result.Uploads, uploadEntry{
+			Key:       upload.key,
+			UploadID:  id,
+			Initiated: upload.initiated.Format(time.RFC3339),
+		})
+	}
+	writeXML(w, result)
+}
+
+type partEntry struct {
+	PartNumber   int    `xml:"PartNumber"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+}
+
+type listPartsResult struct {
+	XMLName     xml.Name    `xml:"ListPartsResult"`
+	Bucket      string      `xml:"Bucket"`
+	Key         string      `xml:"Key"`
+	UploadID    string      `xml:"UploadId"`
+	IsTruncated bool        `xml:"IsTruncated"`
+	Parts       []partEntry `xml:"Part"`
+}
+
+func (server *Server) listParts(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) {
+	upload, ok := server.uploads[uploadID]
+	if !ok || upload.key != key || upload.bucket != bucket {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+	result := listPartsResult{Bucket: bucket, Key: key, UploadID: uploadID}
+	numbers := make([]int, 0, len(upload.parts))
+	for number := range upload.parts {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	for _, number := range numbers {
+		p := upload.parts[number]
+		result.Parts = append(result.Parts, partEntry{
+			PartNumber:   number,
+			LastModified: p.lastModified.Format(time.RFC3339),
+			ETag:         quote(p.etag),
+			Size:         int64(len(p.data)),
+		})
+	}
+	writeXML(w, result)
+}
+
+func metadataFromHeader(header http.Header) map[string]string {
+	metadata := map[string]string{}
+	for name, values := range header {
+		if len(name) > len("X-Amz-Meta-") && strings.EqualFold(name[:len("X-Amz-Meta-")], "X-Amz-Meta-") {
+			metadata[strings.ToLower(name[len("X-Amz-Meta-"):])] = values[0]
+		}
+	}
+	return metadata
+}
+
+func storageClassFromHeader(header http.Header) string {
+	if class := header.Get("X-Amz-Storage-Class"); class != "" {
+		return class
+	}
+	return "STANDARD"
+}
+
+type errorResponse struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(errorResponse{Code: code, Message: message})
+}
+
+func writeXML(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(value)
+}
+
+func quote(etag string) string {
+	return "\"" + etag + "\""
+}
@@ -0,0 +1,128 @@
+package fakes3
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func newClient(server *Server) *s3.Client {
+	return s3.NewFromConfig(aws.Config{}, server.S3Options)
+}
+
+// listAll follows continuation tokens and returns every key and common
+// prefix in the order they were listed.
+func listAll(t *testing.T, client *s3.Client, input *s3.ListObjectsV2Input) ([]string, []string, int) {
+	t.Helper()
+	var keys, prefixes []string
+	pages := 0
+	paginator := s3.NewListObjectsV2Paginator(client, input)
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		for _, object := range output.Contents {
+			keys = append(keys, aws.ToString(object.Key))
+		}
+		for _, prefix := range output.CommonPrefixes {
+			prefixes = append(prefixes, aws.ToString(prefix.Prefix))
+		}
+	}
+	return keys, prefixes, pages
+}
+
+func TestListObjectsV2Pagination(t *testing.T) {
+	server := NewServer("bucket")
+	defer server.Close()
+	var want []string
+	for i := 0; i < 25; i++ {
+		key := fmt.Sprintf("file-%02d", i)
+		server.PutObject("bucket", key, []byte(key), nil)
+		want = append(want, key)
+	}
+
+	keys, _, pages := listAll(t, newClient(server), &s3.ListObjectsV2Input{
+		Bucket:  aws.String("bucket"),
+		MaxKeys: 10,
+	})
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("listed %v, want %v", keys, want)
+	}
+	if pages != 3 {
+		t.Fatalf("listed in %d pages, want 3", pages)
+	}
+}
+
+func TestListObjectsV2PaginationWithDelimiter(t *testing.T) {
+	server := NewServer("bucket")
+	defer server.Close()
+	for _, key := range []string{"a.txt", "dir/1", "dir/2", "dir/3", "dir/sub/4", "dir-x", "other/1", "other/2", "z.txt"} {
+		server.PutObject("bucket", key, []byte(key), nil)
+	}
+
+	// Every page size must list each common prefix exactly once, even when
+	// a page ends inside one.
+	for maxKeys := int32(1); maxKeys <= 6; maxKeys++ {
+		keys, prefixes, _ := listAll(t, newClient(server), &s3.ListObjectsV2Input{
+			Bucket:    aws.String("bucket"),
+			Delimiter: aws.String("/"),
+			MaxKeys:   maxKeys,
+		})
+		if want := []string{"a.txt", "dir-x", "z.txt"}; !reflect.DeepEqual(keys, want) {
+			t.Errorf("max-keys %d: listed keys %v, want %v", maxKeys, keys, want)
+		}
+		if want := []string{"dir/", "other/"}; !reflect.DeepEqual(prefixes, want) {
+			t.Errorf("max-keys %d: listed prefixes %v, want %v", maxKeys, prefixes, want)
+		}
+
+		keys, prefixes, _ = listAll(t, newClient(server), &s3.ListObjectsV2Input{
+			Bucket:    aws.String("bucket"),
+			Prefix:    aws.String("dir/"),
+			Delimiter: aws.String("/"),
+			MaxKeys:   maxKeys,
+		})
+		if want := []string{"dir/1", "dir/2", "dir/3"}; !reflect.DeepEqual(keys, want) {
+			t.Errorf("max-keys %d: listed keys %v below dir/, want %v", maxKeys, keys, want)
+		}
+		if want := []string{"dir/sub/"}; !reflect.DeepEqual(prefixes, want) {
+			t.Errorf("max-keys %d: listed prefixes %v below dir/, want %v", maxKeys, prefixes, want)
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		value      string
+		size       int64
+		start, end int64
+		ok         bool
+	}{
+		{"bytes=0-9", 100, 0, 9, true},
+		{"bytes=10-", 100, 10, 99, true},
+		{"bytes=90-200", 100, 90, 99, true},
+		{"bytes=-10", 100, 90, 99, true},
+		{"bytes=-200", 100, 0, 99, true},
+		{"bytes=99-99", 100, 99, 99, true},
+		{"bytes=100-", 100, 0, 0, false},
+		{"bytes=5-4", 100, 0, 0, false},
+		{"bytes=-0", 100, 0, 0, false},
+		{"bytes=-5", 0, 0, 0, false},
+		{"bytes=0-1,3-4", 100, 0, 0, false},
+		{"items=0-1", 100, 0, 0, false},
+		{"bytes=a-b", 100, 0, 0, false},
+		{"bytes=5", 100, 0, 0, false},
+	}
+	for _, test := range tests {
+		start, end, ok := parseRange(test.value, test.size)
+		if ok != test.ok || ok && (start != test.start || end != test.end) {
+			t.Errorf("parseRange(%q, %d) = %d, %d, %v, want %d, %d, %v",
+				test.value, test.size, start, end, ok, test.start, test.end, test.ok)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Package ignore matches keys against gitignore-style patterns.
+package ignore
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Filename is the ignore file k-drive reads from the root of the working
+// directory.
+const Filename = ".kdriveignore"
+
+type rule struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+// Matcher decides which keys are ignored. The zero value ignores nothing.
+type Matcher struct {
+	rules []rule
+}
+
+// New compiles patterns, one per entry, written like the lines of a
+// .gitignore file.
+func New(patterns []string) *Matcher {
+	matcher := &Matcher{}
+	matcher.Add(patterns)
+	return matcher
+}
+
+// Load compiles patterns followed by the lines of the ignore file at
+// filename, if there is one, so the file can override the patterns.
+func Load(filename string, patterns []string) (*Matcher, error) {
+	matcher := New(patterns)
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return matcher, nil
+	}
+	if err != nil {
+		return matcher, err
+	}
+	defer file.Close()
+	lines, err := readLines(file)
+	matcher.Add(lines)
+	return matcher, err
+}
+
+func readLines(r io.Reader) ([]string, error) {
+	lines := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// Add compiles more patterns, they take precedence over the earlier ones.
+func (matcher *Matcher) Add(patterns []string) {
+	for _, pattern := range patterns {
+		pattern = strings.TrimRight(pattern, " \t\r")
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		r := rule{}
+		if strings.HasPrefix(pattern, "!") {
+			r.negate = true
+			pattern = pattern[1:]
+		} else if strings.HasPrefix(pattern, `\`) {
+			// \# and \! start patterns with a literal # or !
+			pattern = pattern[1:]
+		}
+		if strings.HasSuffix(pattern, "/") {
+			r.dirOnly = true
+			pattern = strings.TrimRight(pattern, "/")
+		}
+		if pattern == "" {
+			continue
+		}
+		// Like git, a pattern without a slash matches at any depth, one
+		// with a slash is relative to the root.
+		if !strings.Contains(pattern, "/") {
+			pattern = "**/" + pattern
+		}
+		r.segments = strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+		matcher.rules = append(matcher.rules, r)
+	}
+}
+
+// Match reports whether key, a slash separated path relative to the root, is
+// ignored. Everything inside an ignored folder is ignored as well.
+func (matcher *Matcher) Match(key string, isDir bool) bool {
+	if matcher == nil || len(matcher.rules) == 0 {
+		return false
+	}
+	key = strings.Trim(key, "/")
+	if key == "" {
+		return false
+	}
+	segments := strings.Split(key, "/")
+	for i := 1; i < len(segments); i++ {
+		if matcher.match(segments[:i], true) {
+			return true
+		}
+	}
+	return matcher.match(segments, isDir)
+}
+
+// match applies the rules to a single path, the last matching rule wins.
+func (matcher *Matcher) match(segments []string, isDir bool) bool {
+	ignored := false
+	for _, r := range matcher.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		if matchSegments(r.segments, segments) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+func matchSegments(pattern []string, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	ok, err := path.Match(pattern[0], segments[0])
+	return ok && err == nil && matchSegments(pattern[1:], segments[1:])
+}
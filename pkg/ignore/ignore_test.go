@@ -0,0 +1,101 @@
+package ignore
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	matcher := New([]string{
+		"# editor files",
+		"*.swp",
+		"~$*",
+		"build/",
+		"/root-only.txt",
+		"docs/*.pdf",
+		"logs/**/debug.log",
+		"*.tmp",
+		"!keep.tmp",
+		`\#literal`,
+		"   ",
+	})
+	tests := []struct {
+		key   string
+		isDir bool
+		want  bool
+	}{
+		{"a.swp", false, true},
+		{"deep/inside/a.swp", false, true},
+		{"~$report.docx", false, true},
+		{"build", true, true},
+		{"build", false, false},
+		{"app/build/out.bin", false, true},
+		{"root-only.txt", false, true},
+		{"sub/root-only.txt", false, false},
+		{"docs/manual.pdf", false, true},
+		{"docs/sub/manual.pdf", false, false},
+		{"other/docs/manual.pdf", false, false},
+		{"logs/debug.log", false, true},
+		{"logs/a/b/debug.log", false, true},
+		{"debug.log", false, false},
+		{"scratch.tmp", false, true},
+		{"keep.tmp", false, false},
+		{"dir/keep.tmp", false, false},
+		{"#literal", false, true},
+		{"# editor files", false, false},
+		{"notes.txt", false, false},
+		{"/a.swp/", false, true},
+		{"", true, false},
+	}
+	for _, test := range tests {
+		if got := matcher.Match(test.key, test.isDir); got != test.want {
+			t.Errorf("Match(%q, %v) = %v, want %v", test.key, test.isDir, got, test.want)
+		}
+	}
+}
+
+func TestLaterPatternsWin(t *testing.T) {
+	matcher := New([]string{"*.log"})
+	matcher.Add([]string{"!important.log"})
+	if !matcher.Match("debug.log", false) || matcher.Match("important.log", false) {
+		t.Fatal("negation added later does not override the earlier pattern")
+	}
+
+	// A file inside an ignored folder cannot be brought back by a negation.
+	matcher = New([]string{"cache/", "!cache/keep.txt"})
+	if !matcher.Match("cache/keep.txt", false) {
+		t.Fatal("file inside an ignored folder is not ignored")
+	}
+}
+
+func TestZeroMatcherIgnoresNothing(t *testing.T) {
+	var matcher *Matcher
+	if matcher.Match("a.swp", false) || (&Matcher{}).Match("a.swp", false) {
+		t.Fatal("empty matcher ignores keys")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, Filename)
+
+	matcher, err := Load(filename, []string{"*.tmp"})
+	if err != nil {
+		t.Fatalf("Load without an ignore file = %v", err)
+	}
+	if !matcher.Match("a.tmp", false) {
+		t.Fatal("default patterns are not applied without an ignore file")
+	}
+
+	if err := ioutil.WriteFile(filename, []byte("# overrides\r\n!wanted.tmp\r\nsecret/\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	matcher, err = Load(filename, []string{"*.tmp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !matcher.Match("a.tmp", false) || matcher.Match("wanted.tmp", false) || !matcher.Match("secret/key", false) {
+		t.Fatal("ignore file does not extend and override the default patterns")
+	}
+}
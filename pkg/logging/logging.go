@@ -25,7 +25,7 @@ func init() {
 	std = &Logger{}
 }
 
-func (logger Logger) Output(lvl int, text string) {
+func (logger *Logger) Output(lvl int, text string) {
 	logger.mu.Lock()
 	defer logger.mu.Unlock()
 	log.Println(text)
@@ -15,19 +15,19 @@ const (
 	Synced      SyncStatus = iota // 0
 	Uploading   SyncStatus = iota // 1
 	Downloading SyncStatus = iota // 2
+	Deleted     SyncStatus = iota // 3
+	Conflict    SyncStatus = iota // 4
+	Queued      SyncStatus = iota // 5
+	Error       SyncStatus = iota // 6
 )
 
-// Todo use date and time to decide who
 type SyncInfo struct {
 	Filename     string
 	DateModified time.Time
 	Location     FileLocation
 	SyncStatus   SyncStatus
-}
-
-type SyncDiff struct {
-	FilesNotAvailableInCloud []SyncInfo
-	FilesNotAvailableLocally []SyncInfo
+	Reason       string // why a transfer failed, set with the Error status
+	Pair         string // ID of the sync pair the file belongs to
 }
 
 func CreateSyncInfo(filename string, dateModified time.Time, location FileLocation, syncStatus SyncStatus) *SyncInfo {
@@ -46,6 +46,14 @@ func (sS SyncStatus) String() string {
 		return "Downloading"
 	} else if sS == Synced {
 		return "Synced"
+	} else if sS == Deleted {
+		return "Deleted"
+	} else if sS == Conflict {
+		return "Conflict"
+	} else if sS == Queued {
+		return "Queued"
+	} else if sS == Error {
+		return "Error"
 	}
 	return "Unknown"
 }
@@ -0,0 +1,109 @@
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	log "github.com/planetsp/k-drive/pkg/logging"
+)
+
+// ChangeQueue remembers which local paths changed while the cloud could not
+// be reached, so they are synced first once it is back, even if k-drive was
+// restarted in between.
+type ChangeQueue struct {
+	mu     sync.Mutex
+	path   string
+	local  string
+	remote string
+	keys   map[string]bool
+}
+
+type queueFile struct {
+	Local  string   `json:"local"`
+	Remote string   `json:"remote"`
+	Keys   []string `json:"keys"`
+}
+
+// LoadChangeQueue reads the queue stored at path. Like the sync state it is
+// only used for the same pairing of local and remote.
+func LoadChangeQueue(path string, local string, remote string) (*ChangeQueue, error) {
+	queue := &ChangeQueue{
+		path:   path,
+		local:  local,
+		remote: remote,
+		keys:   map[string]bool{},
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return queue, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored queueFile
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	if stored.Local != local || stored.Remote != remote {
+		log.Info("Change queue in %s belongs to %s <-> %s, ignoring it", path, stored.Local, stored.Remote)
+		return queue, nil
+	}
+	for _, key := range stored.Keys {
+		queue.keys[key] = true
+	}
+	return queue, nil
+}
+
+func (queue *ChangeQueue) Add(key string) error {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	if queue.keys[key] {
+		return nil
+	}
+	queue.keys[key] = true
+	return queue.save()
+}
+
+func (queue *ChangeQueue) Len() int {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return len(queue.keys)
+}
+
+// Drain empties the queue and returns what was in it, sorted.
+func (queue *ChangeQueue) Drain() ([]string, error) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	keys := make([]string, 0, len(queue.keys))
+	for key := range queue.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) == 0 {
+		return keys, nil
+	}
+	queue.keys = map[string]bool{}
+	return keys, queue.save()
+}
+
+// save must be called with mu held.
+func (queue *ChangeQueue) save() error {
+	stored := queueFile{
+		Local:  queue.local,
+		Remote: queue.remote,
+		Keys:   make([]string, 0, len(queue.keys)),
+	}
+	for key := range queue.keys {
+		stored.Keys = append(stored.Keys, key)
+	}
+	sort.Strings(stored.Keys)
+	data, err := json.MarshalIndent(stored, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(queue.path, data)
+}
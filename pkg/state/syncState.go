@@ -0,0 +1,177 @@
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/planetsp/k-drive/pkg/logging"
+)
+
+const stateFileVersion = 1
+
+// FileState is what both sides looked like the last time a file was in sync.
+type FileState struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	ETag     string    `json:"etag"`
+	Hash     string    `json:"hash,omitempty"`
+	SyncedAt time.Time `json:"syncedAt"`
+}
+
+// SyncState is the persisted index of synced files. It lets the engine tell a
+// file deleted on one side apart from a file that is new on the other.
+type SyncState struct {
+	mu     sync.Mutex
+	path   string
+	local  string
+	remote string
+	files  map[string]*FileState
+	dirty  bool
+}
+
+type stateFile struct {
+	Version int          `json:"version"`
+	Local   string       `json:"local"`
+	Remote  string       `json:"remote"`
+	Files   []*FileState `json:"files"`
+}
+
+// LoadSyncState reads the index stored at path. The index only describes the
+// pairing of local and remote it was written for; if either changed, or no
+// index exists yet, an empty index is returned so nothing is mistaken for a
+// deletion.
+func LoadSyncState(path string, local string, remote string) (*SyncState, error) {
+	st := &SyncState{
+		path:   path,
+		local:  local,
+		remote: remote,
+		files:  map[string]*FileState{},
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored stateFile
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	if stored.Local != local || stored.Remote != remote {
+		log.Info("Sync state in %s belongs to %s <-> %s, starting with an empty state", path, stored.Local, stored.Remote)
+		st.dirty = true
+		return st, nil
+	}
+	for _, file := range stored.Files {
+		st.files[file.Path] = file
+	}
+	return st, nil
+}
+
+// Get returns a copy of the state of key, or nil if it was never synced.
+func (st *SyncState) Get(key string) *FileState {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	file, ok := st.files[key]
+	if !ok {
+		return nil
+	}
+	copied := *file
+	return &copied
+}
+
+func (st *SyncState) Put(file FileState) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.files[file.Path] = &file
+	st.dirty = true
+}
+
+func (st *SyncState) Delete(key string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.files[key]; ok {
+		delete(st.files, key)
+		st.dirty = true
+	}
+}
+
+// Keys returns every known key starting with prefix, sorted.
+func (st *SyncState) Keys(prefix string) []string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	keys := []string{}
+	for key := range st.files {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Save writes the index if it changed since it was loaded or last saved.
+// The file is replaced atomically so a crash never leaves a truncated index.
+func (st *SyncState) Save() error {
+	st.mu.Lock()
+	if !st.dirty {
+		st.mu.Unlock()
+		return nil
+	}
+	stored := stateFile{
+		Version: stateFileVersion,
+		Local:   st.local,
+		Remote:  st.remote,
+		Files:   make([]*FileState, 0, len(st.files)),
+	}
+	for _, file := range st.files {
+		copied := *file
+		stored.Files = append(stored.Files, &copied)
+	}
+	st.dirty = false
+	st.mu.Unlock()
+
+	sort.Slice(stored.Files, func(i, j int) bool {
+		return stored.Files[i].Path < stored.Files[j].Path
+	})
+	data, err := json.MarshalIndent(stored, "", "    ")
+	if err == nil {
+		err = writeFileAtomic(st.path, data)
+	}
+	if err != nil {
+		st.mu.Lock()
+		st.dirty = true
+		st.mu.Unlock()
+	}
+	return err
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
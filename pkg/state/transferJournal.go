@@ -0,0 +1,166 @@
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/planetsp/k-drive/pkg/logging"
+)
+
+const (
+	TransferUpload   = "upload"
+	TransferDownload = "download"
+)
+
+// Transfer is an upload or download that was started but has not finished.
+type Transfer struct {
+	Key       string    `json:"key"`
+	Direction string    `json:"direction"`
+	Size      int64     `json:"size"`
+	StartedAt time.Time `json:"startedAt"`
+
+	// Uploads: the multipart upload holding the parts sent so far, and the
+	// local file it was started for.
+	UploadID string    `json:"uploadId,omitempty"`
+	ModTime  time.Time `json:"modTime,omitempty"`
+
+	// Downloads: the temporary file being filled, the object version it is
+	// filled from and the offsets of the chunks already written.
+	TempPath string  `json:"tempPath,omitempty"`
+	ETag     string  `json:"etag,omitempty"`
+	Chunks   []int64 `json:"chunks,omitempty"`
+}
+
+// TransferJournal records unfinished transfers so they can continue where
+// they stopped after k-drive is restarted. Every change is written through,
+// a transfer is only worth resuming if the journal survived the crash.
+type TransferJournal struct {
+	mu        sync.Mutex
+	path      string
+	local     string
+	remote    string
+	transfers map[string]*Transfer
+}
+
+type journalFile struct {
+	Local     string      `json:"local"`
+	Remote    string      `json:"remote"`
+	Transfers []*Transfer `json:"transfers"`
+}
+
+// LoadTransferJournal reads the journal stored at path. Like the sync state
+// it is only used for the same pairing of local and remote.
+func LoadTransferJournal(path string, local string, remote string) (*TransferJournal, error) {
+	journal := &TransferJournal{
+		path:      path,
+		local:     local,
+		remote:    remote,
+		transfers: map[string]*Transfer{},
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return journal, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored journalFile
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	if stored.Local != local || stored.Remote != remote {
+		log.Info("Transfer journal in %s belongs to %s <-> %s, ignoring it", path, stored.Local, stored.Remote)
+		return journal, nil
+	}
+	for _, transfer := range stored.Transfers {
+		journal.transfers[transfer.Key] = transfer
+	}
+	return journal, nil
+}
+
+// Get returns a copy of the unfinished transfer of key, or nil.
+func (journal *TransferJournal) Get(key string) *Transfer {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	transfer, ok := journal.transfers[key]
+	if !ok {
+		return nil
+	}
+	copied := *transfer
+	copied.Chunks = append([]int64(nil), transfer.Chunks...)
+	return &copied
+}
+
+// All returns copies of every unfinished transfer, sorted by key.
+func (journal *TransferJournal) All() []*Transfer {
+	journal.mu.Lock()
+	keys := make([]string, 0, len(journal.transfers))
+	for key := range journal.transfers {
+		keys = append(keys, key)
+	}
+	journal.mu.Unlock()
+
+	sort.Strings(keys)
+	transfers := make([]*Transfer, 0, len(keys))
+	for _, key := range keys {
+		if transfer := journal.Get(key); transfer != nil {
+			transfers = append(transfers, transfer)
+		}
+	}
+	return transfers
+}
+
+func (journal *TransferJournal) Put(transfer Transfer) error {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	journal.transfers[transfer.Key] = &transfer
+	return journal.save()
+}
+
+// AddChunk records that the chunk of a download starting at offset has been
+// written to its temporary file.
+func (journal *TransferJournal) AddChunk(key string, offset int64) error {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	transfer, ok := journal.transfers[key]
+	if !ok {
+		return nil
+	}
+	transfer.Chunks = append(transfer.Chunks, offset)
+	return journal.save()
+}
+
+func (journal *TransferJournal) Delete(key string) error {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	if _, ok := journal.transfers[key]; !ok {
+		return nil
+	}
+	delete(journal.transfers, key)
+	return journal.save()
+}
+
+// save must be called with mu held.
+func (journal *TransferJournal) save() error {
+	stored := journalFile{
+		Local:     journal.local,
+		Remote:    journal.remote,
+		Transfers: make([]*Transfer, 0, len(journal.transfers)),
+	}
+	for _, transfer := range journal.transfers {
+		stored.Transfers = append(stored.Transfers, transfer)
+	}
+	sort.Slice(stored.Transfers, func(i, j int) bool {
+		return stored.Transfers[i].Key < stored.Transfers[j].Key
+	})
+	data, err := json.MarshalIndent(stored, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(journal.path, data)
+}
@@ -0,0 +1,356 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/planetsp/k-drive/pkg/encryption"
+	log "github.com/planetsp/k-drive/pkg/logging"
+)
+
+// EncryptionParamsKey holds the salt and the passphrase check of an
+// encrypted backend. It is the only object stored in plaintext and the key
+// is reserved, files by that name in the root are not synced.
+const EncryptionParamsKey = ".kdrive-encryption.json"
+
+// EncryptedBackend encrypts everything written to another backend and
+// decrypts everything read from it, optionally including the keys. Keys and
+// sizes it reports are those of the plaintext, ETags are those of the
+// ciphertext.
+type EncryptedBackend struct {
+	backend      StorageBackend
+	passphrase   string
+	encryptNames bool
+
+	mu   sync.Mutex
+	keys *encryption.Keys
+	err  error // a wrong passphrase, which no retry fixes
+}
+
+func NewEncryptedBackend(backend StorageBackend, passphrase string, encryptNames bool) (*EncryptedBackend, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("encryption is enabled but no passphrase is set")
+	}
+	return &EncryptedBackend{backend: backend, passphrase: passphrase, encryptNames: encryptNames}, nil
+}
+
+func (b *EncryptedBackend) Name() string {
+	// The name ends up in the sync state, switching encryption on or off
+	// starts over instead of taking the other side for deleted.
+	if b.encryptNames {
+		return b.backend.Name() + " (encrypted, with encrypted names)"
+	}
+	return b.backend.Name() + " (encrypted)"
+}
+
+// ready returns the keys, deriving them the first time. The salt is read
+// from the backend, or created there when nothing was encrypted yet.
+func (b *EncryptedBackend) ready(ctx context.Context) (*encryption.Keys, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.keys != nil || b.err != nil {
+		return b.keys, b.err
+	}
+
+	params, err := b.readParams(ctx)
+	if err == ErrNotFound {
+		if err := b.createParams(ctx); err != nil && err != ErrExists {
+			return nil, err
+		}
+		// Another computer may have set up the backend at the same time,
+		// whichever salt ended up stored is the one everybody uses.
+		params, err = b.readParams(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys, err := encryption.DeriveKeys(b.passphrase, params)
+	if err == encryption.ErrWrongPassphrase {
+		log.Error(fmt.Sprintf("the passphrase does not match the one %s was encrypted with", b.backend.Name()))
+		b.err = err
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.keys = keys
+	return keys, nil
+}
+
+func (b *EncryptedBackend) readParams(ctx context.Context) (*encryption.Params, error) {
+	body, _, err := b.backend.Get(ctx, EncryptionParamsKey)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	params := &encryption.Params{}
+	if err := json.NewDecoder(body).Decode(params); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", EncryptionParamsKey, err)
+	}
+	return params, nil
+}
+
+// createParams stores new parameters for the passphrase, without replacing
+// ones another computer stored meanwhile where the backend supports it.
+func (b *EncryptedBackend) createParams(ctx context.Context) error {
+	params, _, err := encryption.NewParams(b.passphrase)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	if putter, ok := b.backend.(ConditionalPutter); ok {
+		_, err = putter.PutIfAbsent(ctx, EncryptionParamsKey, bytes.NewReader(data), int64(len(data)), nil)
+	} else {
+		_, err = b.backend.Put(ctx, EncryptionParamsKey, bytes.NewReader(data), int64(len(data)), nil)
+	}
+	if err == nil {
+		log.Info("set up encryption for %s", b.backend.Name())
+	}
+	return err
+}
+
+// checkReserved rejects writes to the key of the encryption parameters.
+func checkReserved(key string) error {
+	if key == EncryptionParamsKey {
+		return fmt.Errorf("%w: %q is reserved for the encryption parameters", ErrInvalidKey, key)
+	}
+	return nil
+}
+
+func (b *EncryptedBackend) encryptKey(keys *encryption.Keys, key string) string {
+	if !b.encryptNames {
+		return key
+	}
+	return keys.EncryptKey(key)
+}
+
+// decryptInfo turns what the wrapped backend reports into what is reported
+// for the plaintext, false if the object was not written by this backend.
+// Metadata that does not decrypt is left out.
+func (b *EncryptedBackend) decryptInfo(keys *encryption.Keys, info ObjectInfo) (ObjectInfo, bool) {
+	if info.Metadata != nil {
+		metadata := map[string]string{}
+		for name, value := range info.Metadata {
+			if plain, err := keys.DecryptValue(value); err == nil {
+				metadata[name] = plain
+			}
+		}
+		info.Metadata = metadata
+	}
+	if b.encryptNames {
+		key, err := keys.DecryptKey(info.Key)
+		if err != nil {
+			return info, false
+		}
+		info.Key = key
+	}
+	if strings.HasSuffix(info.Key, "/") {
+		// Folder markers are empty, there is nothing to decrypt.
+		return info, true
+	}
+	info.Size = encryption.PlaintextSize(info.Size)
+	return info, info.Size >= 0
+}
+
+func (b *EncryptedBackend) CheckConnection(ctx context.Context) error {
+	if err := b.backend.CheckConnection(ctx); err != nil {
+		return err
+	}
+	_, err := b.ready(ctx)
+	return err
+}
+
+func (b *EncryptedBackend) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	keys, err := b.ready(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Only whole folder names can be encrypted, the rest of the prefix is
+	// matched after decrypting.
+	listPrefix := prefix
+	if b.encryptNames {
+		listPrefix = ""
+		if i := strings.LastIndex(prefix, "/"); i >= 0 {
+			listPrefix = keys.EncryptKey(prefix[:i+1])
+		}
+	}
+	encrypted, err := b.backend.List(ctx, listPrefix)
+	if err != nil {
+		return nil, err
+	}
+	objects := []ObjectInfo{}
+	for _, object := range encrypted {
+		if object.Key == EncryptionParamsKey {
+			continue
+		}
+		info, ok := b.decryptInfo(keys, object)
+		if !ok {
+			log.Debug(fmt.Sprintf("skipping %q in %s, it is not encrypted with this passphrase", object.Key, b.backend.Name()))
+			continue
+		}
+		if strings.HasPrefix(info.Key, prefix) {
+			objects = append(objects, info)
+		}
+	}
+	return objects, nil
+}
+
+func (b *EncryptedBackend) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	keys, err := b.ready(ctx)
+	if err != nil {
+		return nil, err
+	}
+	object, err := b.backend.Stat(ctx, b.encryptKey(keys, key))
+	if err != nil {
+		return nil, err
+	}
+	info, ok := b.decryptInfo(keys, *object)
+	if !ok {
+		return nil, fmt.Errorf("%q: %w", key, encryption.ErrCorrupted)
+	}
+	return &info, nil
+}
+
+func (b *EncryptedBackend) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	keys, err := b.ready(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, object, err := b.backend.Get(ctx, b.encryptKey(keys, key))
+	if err != nil {
+		return nil, nil, err
+	}
+	info, ok := b.decryptInfo(keys, *object)
+	if !ok {
+		body.Close()
+		return nil, nil, fmt.Errorf("%q: %w", key, encryption.ErrCorrupted)
+	}
+	plaintext, err := keys.Decrypt(body)
+	if err != nil {
+		body.Close()
+		return nil, nil, fmt.Errorf("%q: %w", key, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{plaintext, body}, &info, nil
+}
+
+// GetRange decrypts the chunks holding the range. Each read is checked
+// against etag, so the header and the chunks come from the same version.
+func (b *EncryptedBackend) GetRange(ctx context.Context, key string, etag string, offset int64, length int64) (io.ReadCloser, error) {
+	keys, err := b.ready(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rangeGetter, ok := b.backend.(RangeGetter)
+	if !ok {
+		body, _, err := b.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.CopyN(ioutil.Discard, body, offset); err != nil {
+			body.Close()
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(body, length), body}, nil
+	}
+
+	encryptedKey := b.encryptKey(keys, key)
+	header, err := readRange(ctx, rangeGetter, encryptedKey, etag, 0, int64(encryption.HeaderSize))
+	if err != nil {
+		return nil, err
+	}
+	cipherOffset, cipherLength, _ := encryption.ChunkRange(offset, length)
+	chunks, err := readRange(ctx, rangeGetter, encryptedKey, etag, cipherOffset, cipherLength)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := keys.DecryptRange(header, chunks, offset, length)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", key, err)
+	}
+	return ioutil.NopCloser(bytes.NewReader(plaintext)), nil
+}
+
+// readRange reads up to length bytes at offset, less at the end of the
+// object.
+func readRange(ctx context.Context, rangeGetter RangeGetter, key string, etag string, offset int64, length int64) ([]byte, error) {
+	body, err := rangeGetter.GetRange(ctx, key, etag, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ioutil.ReadAll(io.LimitReader(body, length))
+}
+
+func (b *EncryptedBackend) Put(ctx context.Context, key string, body io.Reader, size int64, metadata map[string]string) (*ObjectInfo, error) {
+	if err := checkReserved(key); err != nil {
+		return nil, err
+	}
+	keys, err := b.ready(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := keys.Encrypt(body)
+	if err != nil {
+		return nil, err
+	}
+	if size >= 0 {
+		size = encryption.EncryptedSize(size)
+	}
+	var encryptedMetadata map[string]string
+	if metadata != nil {
+		encryptedMetadata = map[string]string{}
+		for name, value := range metadata {
+			if encryptedMetadata[name], err = keys.EncryptValue(value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var object *ObjectInfo
+	if putter, ok := b.backend.(StorageClassPutter); ok {
+		// Storage class rules are written for the names in the working
+		// directory, not the encrypted ones.
+		object, err = putter.PutWithStorageClass(ctx, b.encryptKey(keys, key), ciphertext, size, encryptedMetadata, putter.StorageClassFor(key))
+	} else {
+		object, err = b.backend.Put(ctx, b.encryptKey(keys, key), ciphertext, size, encryptedMetadata)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, _ := b.decryptInfo(keys, *object)
+	return &info, nil
+}
+
+func (b *EncryptedBackend) Delete(ctx context.Context, key string) error {
+	if err := checkReserved(key); err != nil {
+		return err
+	}
+	keys, err := b.ready(ctx)
+	if err != nil {
+		return err
+	}
+	return b.backend.Delete(ctx, b.encryptKey(keys, key))
+}
+
+// AbortStaleUploads passes on to the wrapped backend. Uploads through this
+// backend are never resumed, every attempt encrypts with a new file key.
+func (b *EncryptedBackend) AbortStaleUploads(ctx context.Context, olderThan time.Duration, keep map[string]bool) error {
+	if cleaner, ok := b.backend.(UploadCleaner); ok {
+		return cleaner.AbortStaleUploads(ctx, olderThan, keep)
+	}
+	return nil
+}
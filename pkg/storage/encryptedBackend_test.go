@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func putString(t *testing.T, backend StorageBackend, key string, content string) {
+	t.Helper()
+	if _, err := backend.Put(context.Background(), key, bytes.NewReader([]byte(content)), int64(len(content)), nil); err != nil {
+		t.Fatalf("Put(%q) = %v", key, err)
+	}
+}
+
+func getString(t *testing.T, backend StorageBackend, key string) string {
+	t.Helper()
+	body, _, err := backend.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get(%q) = %v", key, err)
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestPutIfAbsent(t *testing.T) {
+	ctx := context.Background()
+	local, err := NewLocalBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, s3Backend := newFakeS3Backend(t)
+	for _, backend := range []interface {
+		StorageBackend
+		ConditionalPutter
+	}{local, s3Backend} {
+		if _, err := backend.PutIfAbsent(ctx, "a.txt", bytes.NewReader([]byte("first")), 5, nil); err != nil {
+			t.Fatalf("%s: PutIfAbsent of a new key = %v", backend.Name(), err)
+		}
+		if _, err := backend.PutIfAbsent(ctx, "a.txt", bytes.NewReader([]byte("second")), 6, nil); err != ErrExists {
+			t.Fatalf("%s: PutIfAbsent of an existing key = %v, want ErrExists", backend.Name(), err)
+		}
+		if got := getString(t, backend, "a.txt"); got != "first" {
+			t.Fatalf("%s: a.txt holds %q after a refused PutIfAbsent", backend.Name(), got)
+		}
+	}
+}
+
+func TestEncryptedBackendsAgreeOnParams(t *testing.T) {
+	ctx := context.Background()
+	_, plain := newFakeS3Backend(t)
+	first, _ := NewEncryptedBackend(plain, "passphrase", true)
+	second, _ := NewEncryptedBackend(plain, "passphrase", true)
+
+	putString(t, first, "docs/a.txt", "secret")
+	stored, err := first.readParams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The second computer found no parameters either and tries to store
+	// its own, it has to end up with the ones stored first.
+	if err := second.createParams(ctx); err != ErrExists {
+		t.Fatalf("createParams over stored parameters = %v, want ErrExists", err)
+	}
+	if got := getString(t, second, "docs/a.txt"); got != "secret" {
+		t.Fatalf("second backend reads %q", got)
+	}
+	params, err := second.readParams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(params.Salt, stored.Salt) {
+		t.Fatal("parameters were replaced")
+	}
+
+	wrong, _ := NewEncryptedBackend(plain, "other", true)
+	if _, err := wrong.List(ctx, ""); err == nil {
+		t.Fatal("List with a wrong passphrase succeeded")
+	}
+}
+
+func TestEncryptedBackendReservesParamsKey(t *testing.T) {
+	ctx := context.Background()
+	local, err := NewLocalBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend, _ := NewEncryptedBackend(local, "passphrase", false)
+
+	if _, err := backend.Put(ctx, EncryptionParamsKey, bytes.NewReader(nil), 0, nil); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("Put of the parameters key = %v, want ErrInvalidKey", err)
+	}
+	if err := backend.Delete(ctx, EncryptionParamsKey); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("Delete of the parameters key = %v, want ErrInvalidKey", err)
+	}
+
+	// Only the parameters in the root are reserved.
+	nested := "sub/" + EncryptionParamsKey
+	putString(t, backend, nested, "just a file")
+	objects, err := backend.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Key != nested {
+		t.Fatalf("List returned %+v, want only %s", objects, nested)
+	}
+	if got := getString(t, backend, nested); got != "just a file" {
+		t.Fatalf("%s holds %q", nested, got)
+	}
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// transientErrorCodes are S3 error codes that go away on their own.
+var transientErrorCodes = map[string]bool{
+	"RequestTimeout":      true,
+	"SlowDown":            true,
+	"InternalError":       true,
+	"ServiceUnavailable":  true,
+	"Throttling":          true,
+	"ThrottlingException": true,
+}
+
+// IsTransientError reports whether err is worth retrying unchanged later,
+// e.g. a dropped connection, a timeout or the service asking to slow down.
+// Anything else, such as a denied request or a missing bucket, needs the
+// user to fix something first.
+func IsTransientError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var responseError *awshttp.ResponseError
+	if errors.As(err, &responseError) {
+		status := responseError.HTTPStatusCode()
+		if status >= 500 || status == 408 || status == 429 {
+			return true
+		}
+	}
+	var apiError smithy.APIError
+	if errors.As(err, &apiError) {
+		return transientErrorCodes[apiError.ErrorCode()]
+	}
+
+	var sendError *smithyhttp.RequestSendError
+	if errors.As(err, &sendError) {
+		return true
+	}
+	var netError net.Error
+	return errors.As(err, &netError)
+}
@@ -0,0 +1,349 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// metadataDirectory holds one JSON sidecar per object carrying its user
+// metadata, so the data files themselves stay byte for byte what was synced.
+const metadataDirectory = ".kdrive-metadata"
+
+// LocalBackend stores objects as plain files below a root directory, e.g. a
+// mounted NAS share.
+type LocalBackend struct {
+	root   string
+	prefix string
+}
+
+func NewLocalBackend(root string) (*LocalBackend, error) {
+	if root == "" {
+		return nil, fmt.Errorf("local backend directory is not set")
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalBackend{root: root}, nil
+}
+
+// SetPrefix makes the backend use the folder prefix below its root, the same
+// way S3Backend.SetPrefix does for a bucket.
+func (b *LocalBackend) SetPrefix(prefix string) {
+	b.prefix = normalizePrefix(prefix)
+}
+
+// dir is the folder holding the objects, the root or the prefix folder.
+func (b *LocalBackend) dir() string {
+	return filepath.Join(b.root, filepath.FromSlash(b.prefix))
+}
+
+func (b *LocalBackend) Name() string {
+	return "local directory " + b.dir()
+}
+
+func (b *LocalBackend) CheckConnection(ctx context.Context) error {
+	info, err := os.Stat(b.root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", b.root)
+	}
+	return nil
+}
+
+func (b *LocalBackend) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	err := filepath.Walk(b.dir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == b.dir() && os.IsNotExist(err) {
+				// Nothing was stored below the prefix yet.
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path == filepath.Join(b.root, metadataDirectory) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isLocalTempFile(info.Name()) {
+			return nil
+		}
+		key, err := b.keyForPath(path)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		objects = append(objects, b.objectInfo(key, info, nil))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+func (b *LocalBackend) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	path, err := b.pathForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	object := b.objectInfo(key, info, nil)
+	if object.Metadata, err = b.readMetadata(key, object.ETag); err != nil {
+		return nil, err
+	}
+	return &object, nil
+}
+
+func (b *LocalBackend) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	object, err := b.Stat(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	path, err := b.pathForKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, object, nil
+}
+
+func (b *LocalBackend) GetRange(ctx context.Context, key string, etag string, offset int64, length int64) (io.ReadCloser, error) {
+	object, err := b.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" && object.ETag != etag {
+		return nil, fmt.Errorf("%w: %q", ErrChanged, key)
+	}
+	path, err := b.pathForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(f, offset, length), f}, nil
+}
+
+func (b *LocalBackend) Put(ctx context.Context, key string, body io.Reader, size int64, metadata map[string]string) (*ObjectInfo, error) {
+	return b.put(ctx, key, body, size, metadata, true)
+}
+
+// PutIfAbsent is Put failing with ErrExists if key already exists.
+func (b *LocalBackend) PutIfAbsent(ctx context.Context, key string, body io.Reader, size int64, metadata map[string]string) (*ObjectInfo, error) {
+	return b.put(ctx, key, body, size, metadata, false)
+}
+
+func (b *LocalBackend) put(ctx context.Context, key string, body io.Reader, size int64, metadata map[string]string, replace bool) (*ObjectInfo, error) {
+	path, err := b.pathForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	// Write next to the destination and rename so readers never observe a
+	// partially written object.
+	tmp, err := ioutil.TempFile(filepath.Dir(path), localTempFilePrefix)
+	if err != nil {
+		return nil, err
+	}
+	written, err := io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size >= 0 && written != size {
+		err = fmt.Errorf("short write for %q: wrote %d of %d bytes", key, written, size)
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = os.Stat(tmp.Name())
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	// Renames and links keep the modification time, so the ETag is known
+	// before the data is in place. The sidecar names it, which keeps a
+	// crash between writing the two from pairing the data with metadata of
+	// another version.
+	etag := b.objectInfo(key, info, nil).ETag
+	if replace {
+		err = b.writeMetadata(key, etag, metadata)
+		if err == nil {
+			err = os.Rename(tmp.Name(), path)
+		}
+	} else {
+		// Unlike a rename, a link never replaces an existing file.
+		err = os.Link(tmp.Name(), path)
+		os.Remove(tmp.Name())
+		if os.IsExist(err) {
+			return nil, ErrExists
+		}
+		if err == nil {
+			err = b.writeMetadata(key, etag, metadata)
+		}
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return b.Stat(ctx, key)
+}
+
+func (b *LocalBackend) Delete(ctx context.Context, key string) error {
+	path, err := b.pathForKey(key)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	os.Remove(b.metadataPath(key))
+	b.removeEmptyParents(path)
+	return nil
+}
+
+// removeEmptyParents cleans up the folders left behind by deleting path, the
+// same way folders disappear from S3 once their last object is gone.
+func (b *LocalBackend) removeEmptyParents(path string) {
+	for dir := filepath.Dir(path); dir != b.root && strings.HasPrefix(dir, b.root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+const localTempFilePrefix = ".kdrive-put-"
+
+func isLocalTempFile(name string) bool {
+	return strings.HasPrefix(name, localTempFilePrefix)
+}
+
+func (b *LocalBackend) objectInfo(key string, info os.FileInfo, metadata map[string]string) ObjectInfo {
+	return ObjectInfo{
+		Key:          key,
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+		// There is no server to hand out ETags, size and modification time
+		// change whenever the content is rewritten which is all callers need.
+		ETag:     fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()),
+		Metadata: metadata,
+	}
+}
+
+// pathForKey returns where key is stored, refusing keys that would end up
+// outside of the backend directory.
+func (b *LocalBackend) pathForKey(key string) (string, error) {
+	if err := CheckKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(b.dir(), filepath.FromSlash(key)), nil
+}
+
+func (b *LocalBackend) keyForPath(path string) (string, error) {
+	rel, err := filepath.Rel(b.dir(), path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+func (b *LocalBackend) metadataPath(key string) string {
+	return filepath.Join(b.root, metadataDirectory, filepath.FromSlash(b.prefix+key)+".json")
+}
+
+// metadataSidecar is the user metadata of the version of an object with ETag.
+type metadataSidecar struct {
+	ETag     string            `json:"etag"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// readMetadata returns the user metadata of key if the sidecar belongs to the
+// version with etag.
+func (b *LocalBackend) readMetadata(key string, etag string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(b.metadataPath(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sidecar metadataSidecar
+	if err := json.Unmarshal(data, &sidecar); err != nil {
+		return nil, err
+	}
+	if sidecar.ETag != etag {
+		return nil, nil
+	}
+	return sidecar.Metadata, nil
+}
+
+func (b *LocalBackend) writeMetadata(key string, etag string, metadata map[string]string) error {
+	path := b.metadataPath(key)
+	if len(metadata) == 0 {
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(metadataSidecar{ETag: etag, Metadata: metadata})
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), localTempFilePrefix)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
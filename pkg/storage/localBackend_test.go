@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckKey(t *testing.T) {
+	valid := []string{"a.txt", "dir/a.txt", "dir/", "..a", "a..b/c", ".hidden/..."}
+	for _, key := range valid {
+		if err := CheckKey(key); err != nil {
+			t.Errorf("CheckKey(%q) = %v, want nil", key, err)
+		}
+	}
+	invalid := []string{"", "/etc/passwd", "..", "../a", "a/../../b", "a/..", `a\b`, `..\a`}
+	for _, key := range invalid {
+		if err := CheckKey(key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("CheckKey(%q) = %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
+
+func TestLocalBackendRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	backend, err := NewLocalBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.SetPrefix("pair")
+
+	put, err := backend.Put(ctx, "dir/a.txt", strings.NewReader("hello"), 5, map[string]string{MetadataSHA256: "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if put.Size != 5 || put.Metadata[MetadataSHA256] != "abc" {
+		t.Fatalf("Put returned %+v", put)
+	}
+
+	objects, err := backend.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Key != "dir/a.txt" {
+		t.Fatalf("List returned %+v, want only dir/a.txt", objects)
+	}
+
+	body, info, err := backend.Get(ctx, "dir/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(body)
+	body.Close()
+	if string(data) != "hello" || info.ETag != put.ETag {
+		t.Fatalf("Get returned %q, %+v", data, info)
+	}
+
+	part, err := backend.GetRange(ctx, "dir/a.txt", put.ETag, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = ioutil.ReadAll(part)
+	part.Close()
+	if string(data) != "ell" {
+		t.Fatalf("GetRange returned %q, want %q", data, "ell")
+	}
+
+	if err := backend.Delete(ctx, "dir/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Stat(ctx, "dir/a.txt"); err != ErrNotFound {
+		t.Fatalf("Stat after Delete = %v, want ErrNotFound", err)
+	}
+	if _, err := os.Stat(filepath.Join(backend.dir(), "dir")); !os.IsNotExist(err) {
+		t.Fatalf("empty folder left behind: %v", err)
+	}
+}
+
+func TestLocalBackendRejectsKeysOutsideRoot(t *testing.T) {
+	ctx := context.Background()
+	parent := t.TempDir()
+	backend, err := NewLocalBackend(filepath.Join(parent, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	victim := filepath.Join(parent, "victim.txt")
+	if err := ioutil.WriteFile(victim, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := backend.Put(ctx, "../escaped.txt", strings.NewReader("x"), 1, nil); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("Put = %v, want ErrInvalidKey", err)
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escaped.txt")); !os.IsNotExist(err) {
+		t.Fatal("Put wrote outside of the backend directory")
+	}
+	if err := backend.Delete(ctx, "../victim.txt"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("Delete = %v, want ErrInvalidKey", err)
+	}
+	if _, _, err := backend.Get(ctx, "../victim.txt"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("Get = %v, want ErrInvalidKey", err)
+	}
+	if data, _ := ioutil.ReadFile(victim); string(data) != "keep" {
+		t.Fatal("file outside of the backend directory was changed")
+	}
+}
+
+func TestLocalBackendPrefix(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	backend, err := NewLocalBackend(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.SetPrefix("/laptop/docs/")
+	outside, _ := NewLocalBackend(root)
+	for _, key := range []string{"root.txt", "laptop/other.txt", "laptop/docsx/a.txt"} {
+		putString(t, outside, key, "outside")
+	}
+
+	putString(t, backend, "sub/a.txt", "inside")
+	if data, err := ioutil.ReadFile(filepath.Join(root, "laptop", "docs", "sub", "a.txt")); err != nil || string(data) != "inside" {
+		t.Fatalf("sub/a.txt was not stored below the prefix: %q, %v", data, err)
+	}
+	objects, err := backend.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Key != "sub/a.txt" {
+		t.Fatalf("List returned %+v, want only sub/a.txt", objects)
+	}
+	if _, err := backend.Stat(ctx, "root.txt"); err != ErrNotFound {
+		t.Fatalf("Stat of an object outside the prefix = %v, want ErrNotFound", err)
+	}
+	if got := getString(t, outside, "laptop/docs/sub/a.txt"); got != "inside" {
+		t.Fatalf("object below the prefix holds %q", got)
+	}
+}
+
+func TestLocalBackendMetadataSidecars(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	backend, err := NewLocalBackend(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put, err := backend.Put(ctx, "a.txt", strings.NewReader("hello"), 5, map[string]string{MetadataSHA256: "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the sidecars in the root are hidden, not folders of the same
+	// name that were synced.
+	nested := "project/" + metadataDirectory + "/b.txt"
+	putString(t, backend, nested, "synced")
+	objects, err := backend.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	if strings.Join(keys, ",") != "a.txt,"+nested {
+		t.Fatalf("List returned %v, want a.txt and %s", keys, nested)
+	}
+
+	// Data replaced without its sidecar, e.g. by a crash in between, does
+	// not pick up the metadata of the previous version.
+	data := filepath.Join(root, "a.txt")
+	if err := ioutil.WriteFile(data, []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := put.LastModified.Add(time.Second)
+	if err := os.Chtimes(data, later, later); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := backend.Stat(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Metadata[MetadataSHA256] != "" {
+		t.Fatalf("new version of a.txt has the metadata of the previous one, %+v", stat.Metadata)
+	}
+}
@@ -0,0 +1,334 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	c "github.com/planetsp/k-drive/pkg/config"
+	log "github.com/planetsp/k-drive/pkg/logging"
+)
+
+// defaultCustomEndpointRegion is used to sign requests for S3-compatible
+// stores such as MinIO, which accept any region but still require one.
+const defaultCustomEndpointRegion = "us-east-1"
+
+type S3Backend struct {
+	client            *s3.Client
+	bucketName        string
+	prefix            string
+	partSize          int64
+	concurrency       int
+	sse               types.ServerSideEncryption
+	kmsKeyID          *string
+	customerKey       customerKey
+	storageClass      types.StorageClass
+	storageClassRules []storageClassRule
+}
+
+// CreateS3Client builds a client from the shared AWS configuration. optFns
+// are applied on top, e.g. to point the client at another endpoint.
+func CreateS3Client(optFns ...func(*s3.Options)) *s3.Client {
+	// Load the Shared AWS Configuration (~/.aws/config)
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to load AWS configuration: %v", err))
+		log.Info("Ensure you have AWS credentials configured in ~/.aws/credentials or environment variables")
+		return nil
+	}
+
+	// Create an Amazon S3 service client
+	client := s3.NewFromConfig(cfg, optFns...)
+	return client
+}
+
+// S3ClientOptions translates the endpoint settings in cfg into client options,
+// so k-drive can talk to S3-compatible stores like MinIO or Ceph.
+func S3ClientOptions(cfg *c.Configuration) []func(*s3.Options) {
+	optFns := []func(*s3.Options){}
+	if cfg.Region != "" {
+		optFns = append(optFns, func(o *s3.Options) {
+			o.Region = cfg.Region
+		})
+	}
+	if cfg.EndpointURL != "" {
+		optFns = append(optFns, func(o *s3.Options) {
+			o.EndpointResolver = s3.EndpointResolverFromURL(cfg.EndpointURL)
+			if o.Region == "" {
+				o.Region = defaultCustomEndpointRegion
+			}
+		})
+	}
+	if cfg.UsePathStyle {
+		optFns = append(optFns, func(o *s3.Options) {
+			o.UsePathStyle = true
+		})
+	}
+	if cfg.InsecureSkipTLSVerify {
+		log.Info("TLS certificate verification is disabled for the S3 endpoint")
+		optFns = append(optFns, func(o *s3.Options) {
+			o.HTTPClient = awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+				if tr.TLSClientConfig == nil {
+					tr.TLSClientConfig = &tls.Config{}
+				}
+				tr.TLSClientConfig.InsecureSkipVerify = true
+			})
+		})
+	}
+	return optFns
+}
+
+func NewS3Backend(client *s3.Client, bucketName string) *S3Backend {
+	return &S3Backend{
+		client:      client,
+		bucketName:  bucketName,
+		partSize:    defaultPartSize,
+		concurrency: defaultConcurrency,
+	}
+}
+
+// SetPrefix makes the backend use the objects below prefix in the bucket, so
+// several machines or projects can share one bucket. Keys passed to and
+// returned from the backend stay relative to the prefix.
+func (b *S3Backend) SetPrefix(prefix string) {
+	b.prefix = normalizePrefix(prefix)
+}
+
+func (b *S3Backend) objectKey(key string) string {
+	return b.prefix + key
+}
+
+func (b *S3Backend) Name() string {
+	if b.prefix == "" {
+		return "s3://" + b.bucketName
+	}
+	return "s3://" + b.bucketName + "/" + strings.TrimSuffix(b.prefix, "/")
+}
+
+func (b *S3Backend) CheckConnection(ctx context.Context) error {
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(b.bucketName),
+		MaxKeys: 1,
+	}
+	if b.prefix != "" {
+		// Credentials may only be allowed to list below the prefix.
+		input.Prefix = aws.String(b.prefix)
+	}
+	_, err := b.client.ListObjectsV2(ctx, input)
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to connect to AWS S3: %v", err))
+		logS3ErrorHints(b.bucketName, err)
+	}
+	return err
+}
+
+// List returns every object below prefix. S3 returns at most 1000 keys per
+// request, so the listing follows continuation tokens until it is complete.
+func (b *S3Backend) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucketName),
+	}
+	if b.objectKey(prefix) != "" {
+		input.Prefix = aws.String(b.objectKey(prefix))
+	}
+	objects := []ObjectInfo{}
+	paginator := s3.NewListObjectsV2Paginator(b.client, input)
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range output.Contents {
+			key := strings.TrimPrefix(aws.ToString(object.Key), b.prefix)
+			if key == "" {
+				// The folder marker of the prefix itself.
+				continue
+			}
+			objects = append(objects, ObjectInfo{
+				Key:          key,
+				Size:         object.Size,
+				LastModified: aws.ToTime(object.LastModified),
+				ETag:         trimETag(object.ETag),
+			})
+		}
+	}
+	return objects, nil
+}
+
+func (b *S3Backend) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	output, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:               aws.String(b.bucketName),
+		Key:                  aws.String(b.objectKey(key)),
+		SSECustomerAlgorithm: b.customerKey.algorithm,
+		SSECustomerKey:       b.customerKey.key,
+		SSECustomerKeyMD5:    b.customerKey.keyMD5,
+	})
+	if err != nil {
+		return nil, convertS3Error(err)
+	}
+	return &ObjectInfo{
+		Key:          key,
+		Size:         output.ContentLength,
+		LastModified: aws.ToTime(output.LastModified),
+		ETag:         trimETag(output.ETag),
+		Metadata:     output.Metadata,
+	}, nil
+}
+
+func (b *S3Backend) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	output, err := b.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:               aws.String(b.bucketName),
+		Key:                  aws.String(b.objectKey(key)),
+		SSECustomerAlgorithm: b.customerKey.algorithm,
+		SSECustomerKey:       b.customerKey.key,
+		SSECustomerKeyMD5:    b.customerKey.keyMD5,
+	})
+	if err != nil {
+		return nil, nil, convertS3Error(err)
+	}
+	info := &ObjectInfo{
+		Key:          key,
+		Size:         output.ContentLength,
+		LastModified: aws.ToTime(output.LastModified),
+		ETag:         trimETag(output.ETag),
+		Metadata:     output.Metadata,
+	}
+	return output.Body, info, nil
+}
+
+func (b *S3Backend) GetRange(ctx context.Context, key string, etag string, offset int64, length int64) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket:               aws.String(b.bucketName),
+		Key:                  aws.String(b.objectKey(key)),
+		Range:                aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+		SSECustomerAlgorithm: b.customerKey.algorithm,
+		SSECustomerKey:       b.customerKey.key,
+		SSECustomerKeyMD5:    b.customerKey.keyMD5,
+	}
+	if etag != "" {
+		input.IfMatch = aws.String("\"" + etag + "\"")
+	}
+	output, err := b.client.GetObject(ctx, input)
+	var responseError *awshttp.ResponseError
+	if errors.As(err, &responseError) && responseError.HTTPStatusCode() == http.StatusPreconditionFailed {
+		return nil, fmt.Errorf("%w: %q", ErrChanged, key)
+	}
+	if err != nil {
+		return nil, convertS3Error(err)
+	}
+	return output.Body, nil
+}
+
+func (b *S3Backend) Put(ctx context.Context, key string, body io.Reader, size int64, metadata map[string]string) (*ObjectInfo, error) {
+	return b.PutWithStorageClass(ctx, key, body, size, metadata, b.StorageClassFor(key))
+}
+
+// PutWithStorageClass is Put with the storage class chosen by the caller
+// rather than by the rules.
+func (b *S3Backend) PutWithStorageClass(ctx context.Context, key string, body io.Reader, size int64, metadata map[string]string, storageClass string) (*ObjectInfo, error) {
+	if size > b.partSize {
+		return b.putMultipart(ctx, key, body, size, metadata, types.StorageClass(storageClass), "", nil)
+	}
+	return b.putObject(ctx, key, body, size, metadata, types.StorageClass(storageClass))
+}
+
+// PutIfAbsent uploads key in a single request that fails with ErrExists if
+// the key already exists. S3-compatible stores that do not support
+// conditional writes overwrite it instead.
+func (b *S3Backend) PutIfAbsent(ctx context.Context, key string, body io.Reader, size int64, metadata map[string]string) (*ObjectInfo, error) {
+	info, err := b.putObject(ctx, key, body, size, metadata, types.StorageClass(b.StorageClassFor(key)), s3.WithAPIOptions(smithyhttp.AddHeaderValue("If-None-Match", "*")))
+	var responseError *awshttp.ResponseError
+	if errors.As(err, &responseError) && responseError.HTTPStatusCode() == http.StatusPreconditionFailed {
+		return nil, ErrExists
+	}
+	return info, err
+}
+
+func (b *S3Backend) putObject(ctx context.Context, key string, body io.Reader, size int64, metadata map[string]string, storageClass types.StorageClass, optFns ...func(*s3.Options)) (*ObjectInfo, error) {
+	if _, ok := body.(io.ReadSeeker); !ok && size >= 0 {
+		// The request is signed over its body and retried from the start,
+		// streams such as encrypted content are small enough to buffer here.
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(body, buf); err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(buf)
+	}
+	output, err := b.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:               aws.String(b.bucketName),
+		Key:                  aws.String(b.objectKey(key)),
+		Body:                 body,
+		ContentLength:        size,
+		Metadata:             metadata,
+		ServerSideEncryption: b.sse,
+		SSEKMSKeyId:          b.kmsKeyID,
+		StorageClass:         storageClass,
+		SSECustomerAlgorithm: b.customerKey.algorithm,
+		SSECustomerKey:       b.customerKey.key,
+		SSECustomerKeyMD5:    b.customerKey.keyMD5,
+	}, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	// PutObject does not echo the modification time, ask for it so callers
+	// can record exactly what the bucket holds.
+	info, err := b.Stat(ctx, key)
+	if err != nil {
+		return &ObjectInfo{Key: key, Size: size, ETag: trimETag(output.ETag), Metadata: metadata}, nil
+	}
+	return info, nil
+}
+
+func (b *S3Backend) Delete(ctx context.Context, key string) error {
+	_, err := b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucketName),
+		Key:    aws.String(b.objectKey(key)),
+	})
+	return convertS3Error(err)
+}
+
+func trimETag(etag *string) string {
+	return strings.Trim(aws.ToString(etag), "\"")
+}
+
+func convertS3Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	var responseError *awshttp.ResponseError
+	if errors.As(err, &responseError) && responseError.HTTPStatusCode() == 404 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func logS3ErrorHints(bucketName string, err error) {
+	// Provide specific guidance based on error type
+	errorStr := err.Error()
+	if strings.Contains(errorStr, "PermanentRedirect") || strings.Contains(errorStr, "301") {
+		log.Info("Bucket '%s' exists in a different AWS region than configured", bucketName)
+		log.Info("Solutions:")
+		log.Info("1. Update your ~/.aws/config to use the correct region for bucket '%s'", bucketName)
+		log.Info("2. Or create a new bucket in your current region")
+		log.Info("3. Or update the bucket name in conf.json to a bucket in your current region")
+	} else if strings.Contains(errorStr, "region") {
+		log.Info("AWS region configuration issue. Please set your region in ~/.aws/config or AWS_DEFAULT_REGION environment variable")
+	} else if strings.Contains(errorStr, "credentials") || strings.Contains(errorStr, "InvalidAccessKeyId") {
+		log.Info("AWS credentials issue. Please check your ~/.aws/credentials or environment variables")
+	} else if strings.Contains(errorStr, "NoSuchBucket") {
+		log.Info("Bucket '%s' does not exist. Please create it or update conf.json with a valid bucket name", bucketName)
+	} else {
+		log.Info("Please check your AWS configuration. See AWS_SETUP.md for help")
+	}
+}
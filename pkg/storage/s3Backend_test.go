@@ -0,0 +1,389 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	c "github.com/planetsp/k-drive/pkg/config"
+	"github.com/planetsp/k-drive/pkg/fakes3"
+)
+
+const testBucket = "k-drive"
+
+func newFakeS3Backend(t *testing.T) (*fakes3.Server, *S3Backend) {
+	t.Helper()
+	server := fakes3.NewServer(testBucket)
+	t.Cleanup(server.Close)
+	client := CreateS3Client(server.S3Options)
+	if client == nil {
+		t.Fatal("failed to create S3 client")
+	}
+	return server, NewS3Backend(client, testBucket)
+}
+
+func randomBytes(t *testing.T, size int) []byte {
+	t.Helper()
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestS3BackendUploadAndDownload(t *testing.T) {
+	ctx := context.Background()
+	server, backend := newFakeS3Backend(t)
+	backend.SetPrefix("laptop")
+	if err := backend.CheckConnection(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	put, err := backend.Put(ctx, "docs/a.txt", bytes.NewReader([]byte("hello world")), 11, map[string]string{MetadataSHA256: "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if put.Key != "docs/a.txt" || put.Size != 11 || put.ETag == "" {
+		t.Fatalf("Put returned %+v", put)
+	}
+	if data, ok := server.GetObject(testBucket, "laptop/docs/a.txt"); !ok || string(data) != "hello world" {
+		t.Fatalf("bucket holds %q, %v", data, ok)
+	}
+
+	body, info, err := backend.Get(ctx, "docs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(body)
+	body.Close()
+	if string(data) != "hello world" || info.ETag != put.ETag || info.Metadata[MetadataSHA256] != "abc" {
+		t.Fatalf("Get returned %q, %+v", data, info)
+	}
+
+	part, err := backend.GetRange(ctx, "docs/a.txt", put.ETag, 6, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = ioutil.ReadAll(part)
+	part.Close()
+	if string(data) != "world" {
+		t.Fatalf("GetRange returned %q, want %q", data, "world")
+	}
+	if _, err := backend.GetRange(ctx, "docs/a.txt", "outdated", 0, 5); !errors.Is(err, ErrChanged) {
+		t.Fatalf("GetRange of a changed object = %v, want ErrChanged", err)
+	}
+
+	if err := backend.Delete(ctx, "docs/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Stat(ctx, "docs/a.txt"); err != ErrNotFound {
+		t.Fatalf("Stat after Delete = %v, want ErrNotFound", err)
+	}
+	if _, _, err := backend.Get(ctx, "docs/a.txt"); err != ErrNotFound {
+		t.Fatalf("Get after Delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestS3BackendListFollowsContinuationTokens(t *testing.T) {
+	ctx := context.Background()
+	server, backend := newFakeS3Backend(t)
+	backend.SetPrefix("pair")
+	const count = 2345
+	for i := 0; i < count; i++ {
+		server.PutObject(testBucket, fmt.Sprintf("pair/dir-%d/file-%04d", i%7, i), []byte("x"), nil)
+	}
+	server.PutObject(testBucket, "pair/", nil, nil)
+	server.PutObject(testBucket, "elsewhere/file", []byte("x"), nil)
+
+	objects, err := backend.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != count {
+		t.Fatalf("listed %d objects, want %d", len(objects), count)
+	}
+	seen := map[string]bool{}
+	for _, object := range objects {
+		if seen[object.Key] {
+			t.Fatalf("%q listed twice", object.Key)
+		}
+		seen[object.Key] = true
+	}
+	if requests := server.RequestCount("ListObjectsV2"); requests != 3 {
+		t.Fatalf("listed in %d requests, want 3", requests)
+	}
+}
+
+func TestS3BackendMultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	server, backend := newFakeS3Backend(t)
+	backend.SetMultipartOptions(1024, 3)
+	data := randomBytes(t, 10*1024+17)
+
+	// Plain readers are buffered part by part, files are read in place.
+	for _, body := range []io.Reader{bytes.NewBuffer(data), bytes.NewReader(data)} {
+		put, err := backend.Put(ctx, "big.bin", body, int64(len(data)), map[string]string{MetadataSHA256: "abc"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if put.Size != int64(len(data)) || put.Metadata[MetadataSHA256] != "abc" {
+			t.Fatalf("Put returned %+v", put)
+		}
+		if stored, _ := server.GetObject(testBucket, "big.bin"); !bytes.Equal(stored, data) {
+			t.Fatal("stored content differs from the upload")
+		}
+	}
+	if parts := server.RequestCount("UploadPart"); parts != 2*11 {
+		t.Fatalf("uploaded %d parts, want %d", parts, 2*11)
+	}
+	if uploads := server.MultipartUploads(); uploads != 0 {
+		t.Fatalf("%d multipart uploads left open", uploads)
+	}
+}
+
+// failingReader returns an error once limit bytes were read.
+type failingReader struct {
+	data  []byte
+	limit int
+	read  int
+}
+
+var errReadFailed = errors.New("read failed")
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.read >= r.limit {
+		return 0, errReadFailed
+	}
+	if len(p) > r.limit-r.read {
+		p = p[:r.limit-r.read]
+	}
+	n := copy(p, r.data[r.read:])
+	r.read += n
+	return n, nil
+}
+
+func TestS3BackendAbortsFailedMultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	server, backend := newFakeS3Backend(t)
+	backend.SetMultipartOptions(1024, 1)
+	data := randomBytes(t, 8*1024)
+
+	_, err := backend.Put(ctx, "big.bin", &failingReader{data: data, limit: 3 * 1024}, int64(len(data)), nil)
+	if !errors.Is(err, errReadFailed) {
+		t.Fatalf("Put = %v, want the read error", err)
+	}
+	if uploads := server.MultipartUploads(); uploads != 0 {
+		t.Fatalf("%d multipart uploads left open after a failed Put", uploads)
+	}
+	if _, ok := server.GetObject(testBucket, "big.bin"); ok {
+		t.Fatal("failed upload created the object")
+	}
+}
+
+// partFailures answers the first UploadPart requests with the statuses in
+// fail instead of passing them to the server.
+type partFailures struct {
+	mu       sync.Mutex
+	fail     []int
+	attempts int
+}
+
+func (client *partFailures) Do(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodPut || r.URL.Query().Get("partNumber") == "" {
+		return http.DefaultClient.Do(r)
+	}
+	client.mu.Lock()
+	client.attempts++
+	var status int
+	if len(client.fail) > 0 {
+		status, client.fail = client.fail[0], client.fail[1:]
+	}
+	client.mu.Unlock()
+	if status == 0 {
+		return http.DefaultClient.Do(r)
+	}
+	io.Copy(ioutil.Discard, r.Body)
+	code := map[int]string{http.StatusForbidden: "AccessDenied", http.StatusServiceUnavailable: "ServiceUnavailable"}[status]
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/xml"}},
+		Body:       ioutil.NopCloser(strings.NewReader("<Error><Code>" + code + "</Code><Message>injected</Message></Error>")),
+		Request:    r,
+	}, nil
+}
+
+func TestS3BackendRetriesOnlyTransientPartFailures(t *testing.T) {
+	ctx := context.Background()
+	server := fakes3.NewServer(testBucket)
+	t.Cleanup(server.Close)
+	data := randomBytes(t, 2*1024)
+
+	for _, test := range []struct {
+		status   int
+		attempts int
+		fails    bool
+	}{
+		{http.StatusServiceUnavailable, 3, false},
+		{http.StatusForbidden, 1, true},
+	} {
+		parts := &partFailures{fail: []int{test.status}}
+		backend := NewS3Backend(CreateS3Client(server.S3Options, func(o *s3.Options) {
+			o.HTTPClient = parts
+			o.Retryer = aws.NopRetryer{}
+		}), testBucket)
+		backend.SetMultipartOptions(1024, 1)
+
+		_, err := backend.Put(ctx, "big.bin", bytes.NewReader(data), int64(len(data)), nil)
+		if (err != nil) != test.fails {
+			t.Fatalf("Put with a part failing with %d = %v", test.status, err)
+		}
+		if parts.attempts != test.attempts {
+			t.Fatalf("part failing with %d led to %d part requests, want %d", test.status, parts.attempts, test.attempts)
+		}
+	}
+}
+
+func TestS3BackendResumesAndCleansUpMultipartUploads(t *testing.T) {
+	ctx := context.Background()
+	server, backend := newFakeS3Backend(t)
+	backend.SetMultipartOptions(1024, 1)
+	data := randomBytes(t, 8*1024)
+
+	var uploadID string
+	_, err := backend.ResumablePut(ctx, "big.bin", &failingReader{data: data, limit: 3 * 1024}, int64(len(data)), nil, "", func(id string) {
+		uploadID = id
+	})
+	if !errors.Is(err, errReadFailed) || uploadID == "" {
+		t.Fatalf("ResumablePut = %v with upload %q, want the read error", err, uploadID)
+	}
+	if uploads := server.MultipartUploads(); uploads != 1 {
+		t.Fatalf("%d multipart uploads open, want the failed one kept for resuming", uploads)
+	}
+
+	// Uploads still to be resumed and young ones are kept.
+	if err := backend.AbortStaleUploads(ctx, 0, map[string]bool{uploadID: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.AbortStaleUploads(ctx, time.Hour, nil); err != nil {
+		t.Fatal(err)
+	}
+	if uploads := server.MultipartUploads(); uploads != 1 {
+		t.Fatalf("%d multipart uploads open, want 1", uploads)
+	}
+
+	partsBefore := server.RequestCount("UploadPart")
+	if _, err := backend.ResumablePut(ctx, "big.bin", bytes.NewReader(data), int64(len(data)), nil, uploadID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if sent := server.RequestCount("UploadPart") - partsBefore; sent >= 8 {
+		t.Fatalf("resumed upload sent %d of 8 parts, want only the missing ones", sent)
+	}
+	if stored, _ := server.GetObject(testBucket, "big.bin"); !bytes.Equal(stored, data) {
+		t.Fatal("resumed upload stored different content")
+	}
+
+	// An abandoned upload is aborted.
+	_, err = backend.ResumablePut(ctx, "other.bin", &failingReader{data: data, limit: 1024}, int64(len(data)), nil, "", func(string) {})
+	if !errors.Is(err, errReadFailed) {
+		t.Fatalf("ResumablePut = %v, want the read error", err)
+	}
+	if err := backend.AbortStaleUploads(ctx, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if uploads := server.MultipartUploads(); uploads != 0 {
+		t.Fatalf("%d multipart uploads left open", uploads)
+	}
+}
+
+func TestS3BackendStorageClasses(t *testing.T) {
+	ctx := context.Background()
+	server, backend := newFakeS3Backend(t)
+	backend.SetMultipartOptions(1024, 2)
+	backend.SetStorageClasses("STANDARD_IA", []c.StorageClassRule{
+		{Pattern: "archive/", StorageClass: "GLACIER_IR"},
+		{Pattern: "*.iso", StorageClass: "ONEZONE_IA"},
+	})
+
+	uploads := map[string]int{"a.txt": 10, "archive/b.txt": 10, "archive/big.bin": 5000, "archive/disk.iso": 10}
+	for key, size := range uploads {
+		if _, err := backend.Put(ctx, key, bytes.NewReader(randomBytes(t, size)), int64(size), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string]string{"a.txt": "STANDARD_IA", "archive/b.txt": "GLACIER_IR", "archive/big.bin": "GLACIER_IR", "archive/disk.iso": "ONEZONE_IA"}
+	for key, class := range want {
+		if got, _ := server.StorageClass(testBucket, key); got != class {
+			t.Errorf("%s was stored as %q, want %q", key, got, class)
+		}
+	}
+
+	// Rules apply to the names in the working directory, not to the
+	// encrypted ones.
+	encrypted, err := NewEncryptedBackend(backend, "passphrase", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range server.Keys(testBucket) {
+		server.DeleteObject(testBucket, key)
+	}
+	putString(t, encrypted, "archive/secret.txt", "secret")
+	for _, key := range server.Keys(testBucket) {
+		class, _ := server.StorageClass(testBucket, key)
+		if key == EncryptionParamsKey {
+			if class != "STANDARD_IA" {
+				t.Errorf("encryption parameters were stored as %q", class)
+			}
+		} else if class != "GLACIER_IR" {
+			t.Errorf("encrypted %s was stored as %q, want GLACIER_IR", key, class)
+		}
+	}
+}
+
+func TestS3BackendCustomEndpoint(t *testing.T) {
+	server := fakes3.NewServer(testBucket)
+	t.Cleanup(server.Close)
+	// Credentials come from the environment like for any S3-compatible
+	// store, the region falls back to the default for custom endpoints.
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	t.Setenv("AWS_ACCESS_KEY_ID", "minio")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "minio-secret")
+
+	config := &c.Configuration{SyncPair: c.SyncPair{
+		CloudProvider: c.ProviderAwsS3,
+		BucketName:    testBucket,
+		RemotePrefix:  "laptop",
+		EndpointURL:   server.URL,
+		UsePathStyle:  true,
+	}}
+	backend, err := NewStorageBackend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.CheckConnection(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	putString(t, backend, "a.txt", "hello")
+	if data, ok := server.GetObject(testBucket, "laptop/a.txt"); !ok || string(data) != "hello" {
+		t.Fatalf("fake server holds %q, %v", data, ok)
+	}
+	if got := getString(t, backend, "a.txt"); got != "hello" {
+		t.Fatalf("a.txt holds %q", got)
+	}
+}
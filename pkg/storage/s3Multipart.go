@@ -0,0 +1,318 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	log "github.com/planetsp/k-drive/pkg/logging"
+)
+
+const (
+	defaultPartSize    = 16 * 1024 * 1024
+	defaultConcurrency = 4
+	maxParts           = 10000
+	maxPartAttempts    = 3
+)
+
+// SetMultipartOptions configures uploads larger than partSize to be sent in
+// parts of that size, up to concurrency parts at a time. Zero values keep the
+// defaults.
+func (b *S3Backend) SetMultipartOptions(partSize int64, concurrency int) {
+	if partSize > 0 {
+		b.partSize = partSize
+	}
+	if concurrency > 0 {
+		b.concurrency = concurrency
+	}
+}
+
+// uploadPart is one chunk of a multipart upload. body is rewound for every
+// attempt.
+type uploadPart struct {
+	number int32
+	body   io.ReadSeeker
+	size   int64
+}
+
+// ResumablePut is Put for uploads that may have to survive a restart. Files
+// larger than the part size are uploaded in parts; started is called with the
+// ID of the multipart upload so the caller can record it, and passing that ID
+// back as uploadID later skips the parts that already arrived. An upload
+// that fails is kept for resuming rather than aborted.
+func (b *S3Backend) ResumablePut(ctx context.Context, key string, body io.Reader, size int64, metadata map[string]string, uploadID string, started func(uploadID string)) (*ObjectInfo, error) {
+	if size <= b.partSize {
+		return b.Put(ctx, key, body, size, metadata)
+	}
+	return b.putMultipart(ctx, key, body, size, metadata, types.StorageClass(b.StorageClassFor(key)), uploadID, started)
+}
+
+// putMultipart uploads body in parts so objects can exceed the 5 GB limit of
+// a single PutObject, and a failed part only costs that part being sent
+// again. Parts are read straight from the file when body supports ReadAt,
+// otherwise at most a few parts are buffered in memory at a time.
+func (b *S3Backend) putMultipart(ctx context.Context, key string, body io.Reader, size int64, metadata map[string]string, storageClass types.StorageClass, uploadID string, started func(uploadID string)) (*ObjectInfo, error) {
+	partSize := b.partSize
+	if size/partSize >= maxParts {
+		// Grow the parts rather than fail, S3 allows at most 10000 of them.
+		partSize = size/(maxParts-1) + 1
+	}
+
+	var uploaded map[int32]types.CompletedPart
+	if uploadID != "" {
+		var err error
+		uploaded, err = b.uploadedParts(ctx, key, uploadID, size, partSize)
+		if err != nil {
+			log.Info("cannot resume upload of %q, starting over: %v", key, err)
+			b.abortUpload(key, uploadID)
+			uploadID = ""
+		} else {
+			log.Info("resuming upload of %q, %d parts already uploaded", key, len(uploaded))
+		}
+	}
+	if uploadID == "" {
+		created, err := b.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:               aws.String(b.bucketName),
+			Key:                  aws.String(b.objectKey(key)),
+			Metadata:             metadata,
+			ServerSideEncryption: b.sse,
+			SSEKMSKeyId:          b.kmsKeyID,
+			StorageClass:         storageClass,
+			SSECustomerAlgorithm: b.customerKey.algorithm,
+			SSECustomerKey:       b.customerKey.key,
+			SSECustomerKeyMD5:    b.customerKey.keyMD5,
+		})
+		if err != nil {
+			return nil, err
+		}
+		uploadID = aws.ToString(created.UploadId)
+		log.Debug(fmt.Sprintf("started multipart upload %s of %q in parts of %d bytes", uploadID, key, partSize))
+	}
+	if started != nil {
+		started(uploadID)
+	}
+
+	completed, err := b.uploadParts(ctx, key, uploadID, body, size, partSize, uploaded)
+	if err == nil {
+		_, err = b.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:               aws.String(b.bucketName),
+			Key:                  aws.String(b.objectKey(key)),
+			UploadId:             aws.String(uploadID),
+			MultipartUpload:      &types.CompletedMultipartUpload{Parts: completed},
+			SSECustomerAlgorithm: b.customerKey.algorithm,
+			SSECustomerKey:       b.customerKey.key,
+			SSECustomerKeyMD5:    b.customerKey.keyMD5,
+		})
+	}
+	if err != nil {
+		if started == nil {
+			b.abortUpload(key, uploadID)
+		}
+		return nil, err
+	}
+	return b.Stat(ctx, key)
+}
+
+// uploadedParts returns the parts of uploadID that are already stored. They
+// are only usable if they were cut the same way this upload would cut them.
+func (b *S3Backend) uploadedParts(ctx context.Context, key string, uploadID string, size int64, partSize int64) (map[int32]types.CompletedPart, error) {
+	uploaded := map[int32]types.CompletedPart{}
+	paginator := s3.NewListPartsPaginator(b.client, &s3.ListPartsInput{
+		Bucket:               aws.String(b.bucketName),
+		Key:                  aws.String(b.objectKey(key)),
+		UploadId:             aws.String(uploadID),
+		SSECustomerAlgorithm: b.customerKey.algorithm,
+		SSECustomerKey:       b.customerKey.key,
+		SSECustomerKeyMD5:    b.customerKey.keyMD5,
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range output.Parts {
+			expected := size - int64(part.PartNumber-1)*partSize
+			if expected > partSize {
+				expected = partSize
+			}
+			if expected <= 0 || part.Size != expected {
+				return nil, fmt.Errorf("part %d has %d bytes, expected %d", part.PartNumber, part.Size, expected)
+			}
+			uploaded[part.PartNumber] = types.CompletedPart{ETag: part.ETag, PartNumber: part.PartNumber}
+		}
+	}
+	return uploaded, nil
+}
+
+func (b *S3Backend) uploadParts(ctx context.Context, key string, uploadID string, body io.Reader, size int64, partSize int64, uploaded map[int32]types.CompletedPart) ([]types.CompletedPart, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	parts := make(chan uploadPart, b.concurrency)
+	var mu sync.Mutex
+	completed := make([]types.CompletedPart, 0, len(uploaded))
+	for _, part := range uploaded {
+		completed = append(completed, part)
+	}
+	var firstErr error
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+		mu.Unlock()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < b.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range parts {
+				etag, err := b.uploadPartWithRetry(ctx, key, uploadID, part)
+				if err != nil {
+					fail(err)
+					continue
+				}
+				mu.Lock()
+				completed = append(completed, types.CompletedPart{ETag: etag, PartNumber: part.number})
+				mu.Unlock()
+			}
+		}()
+	}
+
+	readerAt, canReadAt := body.(io.ReaderAt)
+	var number int32
+	for offset := int64(0); offset < size && ctx.Err() == nil; offset += partSize {
+		number++
+		length := partSize
+		if size-offset < length {
+			length = size - offset
+		}
+		part := uploadPart{number: number, size: length}
+		_, done := uploaded[number]
+		if done && canReadAt {
+			continue
+		}
+		if canReadAt {
+			part.body = io.NewSectionReader(readerAt, offset, length)
+		} else {
+			buf := make([]byte, length)
+			if _, err := io.ReadFull(body, buf); err != nil {
+				fail(fmt.Errorf("reading part %d of %q: %w", number, key, err))
+				break
+			}
+			part.body = bytes.NewReader(buf)
+		}
+		if done {
+			continue
+		}
+		parts <- part
+	}
+	close(parts)
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	sort.Slice(completed, func(i, j int) bool {
+		return completed[i].PartNumber < completed[j].PartNumber
+	})
+	return completed, nil
+}
+
+func (b *S3Backend) uploadPartWithRetry(ctx context.Context, key string, uploadID string, part uploadPart) (*string, error) {
+	var err error
+	for attempt := 1; attempt <= maxPartAttempts; attempt++ {
+		if _, err = part.body.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		var output *s3.UploadPartOutput
+		output, err = b.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:               aws.String(b.bucketName),
+			Key:                  aws.String(b.objectKey(key)),
+			UploadId:             aws.String(uploadID),
+			PartNumber:           part.number,
+			Body:                 part.body,
+			ContentLength:        part.size,
+			SSECustomerAlgorithm: b.customerKey.algorithm,
+			SSECustomerKey:       b.customerKey.key,
+			SSECustomerKeyMD5:    b.customerKey.keyMD5,
+		})
+		if err == nil {
+			return output.ETag, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !IsTransientError(err) {
+			return nil, err
+		}
+		log.Debug(fmt.Sprintf("part %d of %q failed (attempt %d of %d), %v", part.number, key, attempt, maxPartAttempts, err))
+		select {
+		case <-time.After(time.Duration(attempt) * time.Second):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, fmt.Errorf("part %d of %q failed after %d attempts: %w", part.number, key, maxPartAttempts, err)
+}
+
+// abortUpload discards the parts of a failed upload so they are not billed.
+// It uses its own context as the upload's context may be what failed.
+func (b *S3Backend) abortUpload(key string, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := b.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(b.bucketName),
+		Key:      aws.String(b.objectKey(key)),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("failed to abort multipart upload %s of %q, %v", uploadID, key, err))
+	}
+}
+
+// AbortStaleUploads aborts multipart uploads that were started more than
+// olderThan ago and never completed, e.g. because k-drive was killed halfway
+// through a large file. Their parts are otherwise kept and billed forever.
+// Uploads listed in keep are still going to be resumed.
+func (b *S3Backend) AbortStaleUploads(ctx context.Context, olderThan time.Duration, keep map[string]bool) error {
+	cutoff := time.Now().Add(-olderThan)
+	input := &s3.ListMultipartUploadsInput{Bucket: aws.String(b.bucketName)}
+	if b.prefix != "" {
+		// Uploads outside the prefix belong to whoever else uses the bucket.
+		input.Prefix = aws.String(b.prefix)
+	}
+	for {
+		output, err := b.client.ListMultipartUploads(ctx, input)
+		if err != nil {
+			return err
+		}
+		for _, upload := range output.Uploads {
+			if (upload.Initiated != nil && upload.Initiated.After(cutoff)) || keep[aws.ToString(upload.UploadId)] {
+				continue
+			}
+			key := strings.TrimPrefix(aws.ToString(upload.Key), b.prefix)
+			log.Info("aborting abandoned upload of %q started %s", key, aws.ToTime(upload.Initiated).Format(time.RFC3339))
+			b.abortUpload(key, aws.ToString(upload.UploadId))
+		}
+		if !output.IsTruncated {
+			return nil
+		}
+		input.KeyMarker = output.NextKeyMarker
+		input.UploadIdMarker = output.NextUploadIdMarker
+	}
+}
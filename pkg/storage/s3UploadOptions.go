@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	c "github.com/planetsp/k-drive/pkg/config"
+	"github.com/planetsp/k-drive/pkg/ignore"
+)
+
+// customerKey holds the SSE-C headers. S3 does not store the key, so every
+// request that writes or reads object data has to send it. All fields are
+// nil when SSE-C is not used.
+type customerKey struct {
+	algorithm *string
+	key       *string
+	keyMD5    *string
+}
+
+type storageClassRule struct {
+	matcher *ignore.Matcher
+	class   types.StorageClass
+}
+
+// SetServerSideEncryption makes S3 encrypt uploaded objects with mode, one of
+// the c.SSE constants. kmsKeyID selects the key for SSE-KMS, the AWS managed
+// key if empty; customerKeyFile holds the 256-bit key for SSE-C, raw or
+// base64 encoded.
+func (b *S3Backend) SetServerSideEncryption(mode string, kmsKeyID string, customerKeyFile string) error {
+	b.sse = ""
+	b.kmsKeyID = nil
+	b.customerKey = customerKey{}
+	switch mode {
+	case "":
+	case c.SSES3:
+		b.sse = types.ServerSideEncryptionAes256
+	case c.SSEKMS:
+		b.sse = types.ServerSideEncryptionAwsKms
+		if kmsKeyID != "" {
+			b.kmsKeyID = aws.String(kmsKeyID)
+		}
+	case c.SSEC:
+		key, err := readCustomerKey(customerKeyFile)
+		if err != nil {
+			return err
+		}
+		sum := md5.Sum(key)
+		b.customerKey = customerKey{
+			algorithm: aws.String(string(types.ServerSideEncryptionAes256)),
+			key:       aws.String(base64.StdEncoding.EncodeToString(key)),
+			keyMD5:    aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+		}
+	default:
+		return fmt.Errorf("unsupported server-side encryption %q", mode)
+	}
+	return nil
+}
+
+func readCustomerKey(filename string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSE-C key: %w", err)
+	}
+	if len(data) == 32 {
+		return data, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("SSE-C key in %s must be 32 bytes, raw or base64 encoded", filename)
+	}
+	return key, nil
+}
+
+// SetStorageClasses uploads objects with defaultClass, or with the class of
+// the last rule matching their key. An empty class leaves the choice to the
+// bucket, which is STANDARD unless configured otherwise.
+func (b *S3Backend) SetStorageClasses(defaultClass string, rules []c.StorageClassRule) {
+	b.storageClass = types.StorageClass(defaultClass)
+	b.storageClassRules = nil
+	for _, rule := range rules {
+		b.storageClassRules = append(b.storageClassRules, storageClassRule{
+			matcher: ignore.New([]string{rule.Pattern}),
+			class:   types.StorageClass(rule.StorageClass),
+		})
+	}
+}
+
+// StorageClassFor picks the storage class of an upload of key, "" for the
+// default of the bucket.
+func (b *S3Backend) StorageClassFor(key string) string {
+	class := b.storageClass
+	for _, rule := range b.storageClassRules {
+		if rule.matcher.Match(key, false) {
+			class = rule.class
+		}
+	}
+	return string(class)
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	c "github.com/planetsp/k-drive/pkg/config"
+)
+
+// ErrNotFound is returned by a StorageBackend when the requested key does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// ErrInvalidKey is returned for keys that cannot be mapped to a path below a
+// local directory, see CheckKey.
+var ErrInvalidKey = errors.New("invalid key")
+
+// ErrExists is returned by PutIfAbsent when the key already exists.
+var ErrExists = errors.New("object already exists")
+
+// ErrChanged is returned by GetRange when the object no longer has the ETag
+// it was read with.
+var ErrChanged = errors.New("object changed while it was read")
+
+// MetadataSHA256 is the metadata key holding the hex encoded SHA-256 of the
+// content an object was uploaded with.
+const MetadataSHA256 = "sha256"
+
+// ObjectInfo describes a single object held by a StorageBackend.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+	Metadata     map[string]string
+}
+
+// StorageBackend is the remote side of a sync. Keys are always relative,
+// '/' separated paths regardless of the local operating system.
+type StorageBackend interface {
+	// Name is a human readable description used in log messages.
+	Name() string
+	// CheckConnection verifies the backend is reachable and usable.
+	CheckConnection(ctx context.Context) error
+	// List returns every object whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	Put(ctx context.Context, key string, body io.Reader, size int64, metadata map[string]string) (*ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// RangeGetter is implemented by backends that can read part of an object, so
+// large files can be downloaded in parallel chunks. The read fails if the
+// object no longer has the given ETag, with ErrChanged, which keeps all chunks
+// of one download from the same version.
+type RangeGetter interface {
+	GetRange(ctx context.Context, key string, etag string, offset int64, length int64) (io.ReadCloser, error)
+}
+
+// UploadResumer is implemented by backends whose uploads can continue after
+// a restart. started reports the ID to pass back as uploadID to continue the
+// upload; it is not called for uploads too small to be worth resuming.
+type UploadResumer interface {
+	ResumablePut(ctx context.Context, key string, body io.Reader, size int64, metadata map[string]string, uploadID string, started func(uploadID string)) (*ObjectInfo, error)
+}
+
+// ConditionalPutter is implemented by backends that can create an object
+// only if its key does not exist yet, returning ErrExists otherwise.
+type ConditionalPutter interface {
+	PutIfAbsent(ctx context.Context, key string, body io.Reader, size int64, metadata map[string]string) (*ObjectInfo, error)
+}
+
+// StorageClassPutter is implemented by backends that store objects in
+// different storage classes. StorageClassFor picks the class for a key as
+// the sync engine names it; backends wrapping this one that store objects
+// under other keys resolve the class from the original key and upload with
+// PutWithStorageClass.
+type StorageClassPutter interface {
+	StorageClassFor(key string) string
+	PutWithStorageClass(ctx context.Context, key string, body io.Reader, size int64, metadata map[string]string, storageClass string) (*ObjectInfo, error)
+}
+
+// UploadCleaner is implemented by backends where interrupted uploads leave
+// data behind that has to be removed explicitly.
+type UploadCleaner interface {
+	AbortStaleUploads(ctx context.Context, olderThan time.Duration, keep map[string]bool) error
+}
+
+// CheckKey rejects keys that would resolve to a path outside of the folder
+// they are stored in, e.g. when an object in a shared bucket is named
+// "../../.bashrc".
+func CheckKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") || filepath.IsAbs(filepath.FromSlash(key)) {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." {
+			return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+	return nil
+}
+
+// normalizePrefix turns a remote prefix into the form keys are joined with,
+// "" for the root or a path ending in '/'.
+func normalizePrefix(prefix string) string {
+	prefix = strings.Trim(strings.ReplaceAll(prefix, "\\", "/"), "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+func NewStorageBackend(config *c.Configuration) (StorageBackend, error) {
+	backend, err := newProviderBackend(config)
+	if err != nil || !config.Encrypt {
+		return backend, err
+	}
+	passphrase, err := c.LoadPassphrase(config.ID)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := NewEncryptedBackend(backend, passphrase, config.EncryptFilenames)
+	if err != nil {
+		return nil, err
+	}
+	return encrypted, nil
+}
+
+func newProviderBackend(config *c.Configuration) (StorageBackend, error) {
+	switch config.CloudProvider {
+	case c.ProviderAwsS3, "":
+		client := CreateS3Client(S3ClientOptions(config)...)
+		if client == nil {
+			return nil, fmt.Errorf("failed to create S3 client")
+		}
+		backend := NewS3Backend(client, config.BucketName)
+		backend.SetMultipartOptions(config.MultipartPartSizeMB*1024*1024, config.MultipartConcurrency)
+		backend.SetPrefix(config.RemotePrefix)
+		backend.SetStorageClasses(config.StorageClass, config.StorageClassRules)
+		if err := backend.SetServerSideEncryption(config.ServerSideEncryption, config.KMSKeyID, config.SSECustomerKeyFile); err != nil {
+			return nil, err
+		}
+		return backend, nil
+	case c.ProviderLocal:
+		backend, err := NewLocalBackend(config.LocalBackendDirectory)
+		if err != nil {
+			return nil, err
+		}
+		backend.SetPrefix(config.RemotePrefix)
+		return backend, nil
+	}
+	return nil, fmt.Errorf("unsupported cloud provider %q", config.CloudProvider)
+}
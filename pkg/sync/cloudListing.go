@@ -0,0 +1,123 @@
+package sync
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/planetsp/k-drive/pkg/logging"
+	"github.com/planetsp/k-drive/pkg/storage"
+)
+
+// singlePageListing is how many keys S3 returns per listing request. Smaller
+// buckets are cheap to list and are always listed in full.
+const singlePageListing = 1000
+
+// cloudListing is a TTL cache of the last full listing of the backend, so
+// polls of a large bucket do not list every key each time. It is not an
+// incremental listing: every refresh lists the whole bucket, page by page.
+// Buckets of up to singlePageListing objects are refreshed on every call,
+// larger ones once the listing is older than refreshInterval. In between
+// the engine keeps the cache up to date with its own uploads, deletes and
+// lookups, so changes made by other clients only show up with the next
+// refresh.
+type cloudListing struct {
+	mu              sync.Mutex
+	objects         map[string]storage.ObjectInfo
+	listedAt        time.Time
+	refreshInterval time.Duration
+	// listing counts the listings in progress and changes collects the
+	// engine's own changes made meanwhile, nil for removed keys. A listing
+	// may have missed them, so they are applied on top of its result.
+	listing int
+	changes map[string]*storage.ObjectInfo
+}
+
+func newCloudListing(refreshInterval time.Duration) *cloudListing {
+	return &cloudListing{refreshInterval: refreshInterval}
+}
+
+// Objects returns the cloud contents keyed by object key, listing the backend
+// again if the cache is missing, small or older than the refresh interval.
+func (l *cloudListing) Objects(ctx context.Context, backend storage.StorageBackend) (map[string]*storage.ObjectInfo, error) {
+	l.mu.Lock()
+	stale := l.objects == nil || len(l.objects) <= singlePageListing ||
+		time.Since(l.listedAt) >= l.refreshInterval
+	l.mu.Unlock()
+
+	if stale {
+		if err := l.refresh(ctx, backend); err != nil {
+			return nil, err
+		}
+	} else {
+		log.Debug("using cached cloud listing")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cloud := make(map[string]*storage.ObjectInfo, len(l.objects))
+	for key, object := range l.objects {
+		copied := object
+		cloud[key] = &copied
+	}
+	return cloud, nil
+}
+
+func (l *cloudListing) refresh(ctx context.Context, backend storage.StorageBackend) error {
+	l.mu.Lock()
+	if l.listing == 0 {
+		l.changes = map[string]*storage.ObjectInfo{}
+	}
+	l.listing++
+	l.mu.Unlock()
+
+	listedAt := time.Now()
+	objects, err := backend.List(ctx, "")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	changes := l.changes
+	l.listing--
+	if l.listing == 0 {
+		l.changes = nil
+	}
+	if err != nil {
+		return err
+	}
+	listed := make(map[string]storage.ObjectInfo, len(objects))
+	for _, object := range objects {
+		listed[object.Key] = object
+	}
+	for key, object := range changes {
+		if object == nil {
+			delete(listed, key)
+		} else {
+			listed[key] = *object
+		}
+	}
+	l.objects = listed
+	l.listedAt = listedAt
+	return nil
+}
+
+// Put records an object that is known to exist, e.g. after an upload.
+func (l *cloudListing) Put(object storage.ObjectInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.objects != nil {
+		l.objects[object.Key] = object
+	}
+	if l.changes != nil {
+		l.changes[object.Key] = &object
+	}
+}
+
+// Remove records that key no longer exists in the cloud.
+func (l *cloudListing) Remove(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.objects, key)
+	if l.changes != nil {
+		l.changes[key] = nil
+	}
+}
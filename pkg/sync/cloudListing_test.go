@@ -0,0 +1,167 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/planetsp/k-drive/pkg/fakes3"
+	"github.com/planetsp/k-drive/pkg/storage"
+)
+
+// pausedListing lists the backend and then waits for resume before
+// returning, so changes can be made while a listing is in progress.
+type pausedListing struct {
+	storage.StorageBackend
+	once   sync.Once
+	listed chan bool
+	resume chan bool
+}
+
+func newPausedListing(backend storage.StorageBackend) *pausedListing {
+	return &pausedListing{StorageBackend: backend, listed: make(chan bool), resume: make(chan bool)}
+}
+
+func (backend *pausedListing) List(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	objects, err := backend.StorageBackend.List(ctx, prefix)
+	paused := false
+	backend.once.Do(func() { paused = true })
+	if paused {
+		close(backend.listed)
+		<-backend.resume
+	}
+	return objects, err
+}
+
+func TestCloudListingKeepsChangesMadeWhileListing(t *testing.T) {
+	ctx := context.Background()
+	local, err := storage.NewLocalBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= singlePageListing; i++ {
+		putObject(t, local, fmt.Sprintf("many/%04d", i))
+	}
+	putObject(t, local, "removed.txt")
+	backend := newPausedListing(local)
+	listing := newCloudListing(time.Hour)
+
+	done := make(chan error)
+	go func() {
+		_, err := listing.Objects(ctx, backend)
+		done <- err
+	}()
+	<-backend.listed
+	listing.Put(storage.ObjectInfo{Key: "uploaded.txt", Size: 1})
+	listing.Remove("removed.txt")
+	close(backend.resume)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// The bucket is large enough for the cached listing to be used.
+	objects, err := listing.Objects(ctx, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objects["uploaded.txt"] == nil || objects["removed.txt"] != nil {
+		t.Fatal("changes made while listing were lost")
+	}
+}
+
+func TestUploadWhileListingIsNotDeleted(t *testing.T) {
+	pair := newLocalTestPair(t)
+	backend := newPausedListing(pair.backend)
+	pair.backend = backend
+	pair.writeLocal("new.txt", "hello")
+	client := pair.client()
+
+	done := make(chan error)
+	go func() { done <- client.ReconcileAll() }()
+	<-backend.listed
+	// Uploaded by a filesystem event after the listing missed it.
+	if err := client.UploadFileToCloud("new.txt"); err != nil {
+		t.Fatal(err)
+	}
+	close(backend.resume)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	pair.waitIdle(client)
+
+	if _, ok := pair.readLocal("new.txt"); !ok {
+		t.Fatal("new.txt was deleted locally")
+	}
+	if _, ok := pair.readCloud("new.txt"); !ok {
+		t.Fatal("new.txt is missing in the cloud")
+	}
+}
+
+func putObject(t *testing.T, backend storage.StorageBackend, key string) {
+	t.Helper()
+	if _, err := backend.Put(context.Background(), key, strings.NewReader("x"), 1, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloudListingRefreshesInFull(t *testing.T) {
+	ctx := context.Background()
+	server := fakes3.NewServer("k-drive")
+	t.Cleanup(server.Close)
+	backend := storage.NewS3Backend(storage.CreateS3Client(server.S3Options), "k-drive")
+	listing := newCloudListing(time.Hour)
+	listRequests := func() int { return server.RequestCount("ListObjectsV2") }
+
+	// Small buckets are listed on every call.
+	server.PutObject("k-drive", "a.txt", []byte("a"), nil)
+	for i := 1; i <= 2; i++ {
+		if _, err := listing.Objects(ctx, backend); err != nil {
+			t.Fatal(err)
+		}
+		if requests := listRequests(); requests != i {
+			t.Fatalf("%d listing requests after %d calls, want %d", requests, i, i)
+		}
+	}
+
+	// Larger ones are cached until the listing is older than the interval.
+	for i := 0; i < singlePageListing; i++ {
+		server.PutObject("k-drive", fmt.Sprintf("many/%04d", i), []byte("x"), nil)
+	}
+	objects, err := listing.Objects(ctx, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != singlePageListing+1 {
+		t.Fatalf("listed %d objects, want %d", len(objects), singlePageListing+1)
+	}
+	before := listRequests()
+	server.PutObject("k-drive", "other-client.txt", []byte("x"), nil)
+	listing.Put(storage.ObjectInfo{Key: "uploaded.txt", Size: 1})
+	listing.Remove("a.txt")
+	objects, err = listing.Objects(ctx, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests := listRequests(); requests != before {
+		t.Fatalf("cached listing sent %d requests", requests-before)
+	}
+	if objects["uploaded.txt"] == nil || objects["a.txt"] != nil || objects["other-client.txt"] != nil {
+		t.Fatal("cached listing does not reflect only the engine's own changes")
+	}
+
+	// A refresh lists the whole bucket again, page by page.
+	listing.refreshInterval = 0
+	objects, err = listing.Objects(ctx, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests := listRequests() - before; requests != 2 {
+		t.Fatalf("refresh sent %d listing requests, want 2", requests)
+	}
+	if objects["other-client.txt"] == nil || objects["a.txt"] == nil || objects["uploaded.txt"] != nil {
+		t.Fatal("refreshed listing does not match the bucket")
+	}
+}
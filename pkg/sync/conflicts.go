@@ -0,0 +1,105 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	c "github.com/planetsp/k-drive/pkg/config"
+	log "github.com/planetsp/k-drive/pkg/logging"
+	s "github.com/planetsp/k-drive/pkg/models"
+	"github.com/planetsp/k-drive/pkg/storage"
+)
+
+// resolveConflict handles a key that changed both locally and in the cloud
+// according to the configured conflict policy.
+func (client *SyncClient) resolveConflict(key string, local *localFile, cloud *storage.ObjectInfo) {
+	log.Info("conflict: %q changed both locally and in the cloud, resolving with policy %q", key, client.conflictPolicy)
+	client.report(&s.SyncInfo{
+		Filename:     key,
+		DateModified: local.ModTime,
+		Location:     s.Local,
+		SyncStatus:   s.Conflict,
+	})
+
+	switch client.conflictPolicy {
+	case c.ConflictLocalWins:
+		client.runTransfer(key, local.Size, s.Local, client.UploadFileToCloud)
+	case c.ConflictCloudWins:
+		client.runTransfer(key, cloud.Size, s.Cloud, client.downloadReplacing(local))
+	case c.ConflictNewestWins:
+		if newestWins(local, cloud) == actionUpload {
+			client.runTransfer(key, local.Size, s.Local, client.UploadFileToCloud)
+		} else {
+			client.runTransfer(key, cloud.Size, s.Cloud, client.downloadReplacing(local))
+		}
+	default:
+		client.runTransfer(key, cloud.Size, s.Local, client.keepBothVersions)
+	}
+}
+
+// keepBothVersions moves the local copy of key aside under a conflict name
+// and downloads the cloud copy in its place. The conflict copy is a new file
+// and gets uploaded by the next reconcile, so no edit is lost on either side.
+func (client *SyncClient) keepBothVersions(key string) error {
+	if err := client.moveConflictCopy(key); err != nil {
+		return err
+	}
+	return client.DownloadFileFromCloud(key)
+}
+
+// moveConflictCopy renames the local copy of key to a conflict name.
+func (client *SyncClient) moveConflictCopy(key string) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown host"
+	}
+	localPath, err := LocalPathForKey(client.workingDirectory, key)
+	if err != nil {
+		return err
+	}
+	conflictKey := conflictCopyName(key, hostname, time.Now(), func(candidate string) bool {
+		candidatePath, err := LocalPathForKey(client.workingDirectory, candidate)
+		if err != nil {
+			return false
+		}
+		_, err = os.Stat(candidatePath)
+		return err == nil
+	})
+	conflictPath, err := LocalPathForKey(client.workingDirectory, conflictKey)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(localPath, conflictPath); err != nil {
+		return fmt.Errorf("failed to keep conflicting copy of %q: %w", key, err)
+	}
+	log.Info("kept local version of %q as %q", key, conflictKey)
+	client.report(&s.SyncInfo{
+		Filename:     conflictKey,
+		DateModified: time.Now(),
+		Location:     s.Local,
+		SyncStatus:   s.Conflict,
+	})
+	return nil
+}
+
+// conflictCopyName turns "docs/report.docx" into
+// "docs/report (conflicted copy from host 2026-10-17).docx", adding a counter
+// when exists reports that name is already taken.
+func conflictCopyName(key string, hostname string, when time.Time, exists func(string) bool) string {
+	dir, base := path.Split(key)
+	ext := path.Ext(base)
+	name := strings.TrimSuffix(base, ext)
+	if name == "" {
+		// Dot files such as ".bashrc" have no extension, only a name
+		name, ext = base, ""
+	}
+	label := fmt.Sprintf("conflicted copy from %s %s", hostname, when.Format("2006-01-02"))
+	candidate := fmt.Sprintf("%s%s (%s)%s", dir, name, label, ext)
+	for i := 2; exists(candidate); i++ {
+		candidate = fmt.Sprintf("%s%s (%s %d)%s", dir, name, label, i, ext)
+	}
+	return candidate
+}
@@ -0,0 +1,108 @@
+package sync
+
+import (
+	"testing"
+	"time"
+
+	c "github.com/planetsp/k-drive/pkg/config"
+)
+
+func TestConflictCopyName(t *testing.T) {
+	when := time.Date(2026, 10, 17, 23, 59, 0, 0, time.UTC)
+	none := func(string) bool { return false }
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"report.docx", "report (conflicted copy from laptop 2026-10-17).docx"},
+		{"docs/report.docx", "docs/report (conflicted copy from laptop 2026-10-17).docx"},
+		{"docs/archive.tar.gz", "docs/archive.tar (conflicted copy from laptop 2026-10-17).gz"},
+		{"Makefile", "Makefile (conflicted copy from laptop 2026-10-17)"},
+		{".bashrc", ".bashrc (conflicted copy from laptop 2026-10-17)"},
+		{"dot.dir/notes", "dot.dir/notes (conflicted copy from laptop 2026-10-17)"},
+	}
+	for _, test := range tests {
+		if got := conflictCopyName(test.key, "laptop", when, none); got != test.want {
+			t.Errorf("conflictCopyName(%q) = %q, want %q", test.key, got, test.want)
+		}
+	}
+
+	taken := map[string]bool{
+		"a (conflicted copy from laptop 2026-10-17).txt":   true,
+		"a (conflicted copy from laptop 2026-10-17 2).txt": true,
+	}
+	got := conflictCopyName("a.txt", "laptop", when, func(candidate string) bool { return taken[candidate] })
+	if want := "a (conflicted copy from laptop 2026-10-17 3).txt"; got != want {
+		t.Errorf("conflictCopyName with taken names = %q, want %q", got, want)
+	}
+}
+
+func TestConflictPolicies(t *testing.T) {
+	tests := []struct {
+		policy    string
+		local     string
+		cloud     string
+		keptLocal bool
+	}{
+		{c.ConflictKeepBoth, "cloud", "cloud", true},
+		{c.ConflictLocalWins, "local", "local", false},
+		{c.ConflictCloudWins, "cloud", "cloud", false},
+	}
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			pair := newLocalTestPair(t)
+			pair.config.ConflictPolicy = test.policy
+			pair.writeLocal("a.txt", "first")
+			client := pair.client()
+			pair.reconcile(client)
+
+			// Edit both sides, making sure the local time changes.
+			time.Sleep(10 * time.Millisecond)
+			pair.writeLocal("a.txt", "local")
+			pair.putCloud("a.txt", "cloud")
+			pair.reconcile(client)
+
+			if local, _ := pair.readLocal("a.txt"); local != test.local {
+				t.Errorf("local copy holds %q, want %q", local, test.local)
+			}
+			if cloud, _ := pair.readCloud("a.txt"); cloud != test.cloud {
+				t.Errorf("cloud copy holds %q, want %q", cloud, test.cloud)
+			}
+			copies := pair.conflictCopies("a.txt")
+			if test.keptLocal && (len(copies) != 1 || copies[0] != "local") {
+				t.Errorf("conflict copies %q, want the local copy", copies)
+			}
+			if !test.keptLocal && len(copies) != 0 {
+				t.Errorf("conflict copies %q, want none", copies)
+			}
+		})
+	}
+}
+
+func TestQueuedDownloadKeepsLocalEdits(t *testing.T) {
+	pair := newLocalTestPair(t)
+	pair.config.TransferWorkers = 1
+	pair.writeLocal("doc.txt", "v1")
+	client := pair.client()
+	pair.reconcile(client)
+
+	// Changed in the cloud, then edited locally while the download waits.
+	release := pair.blockWorker(client)
+	pair.putCloud("doc.txt", "cloud v2")
+	if err := client.ReconcileAll(); err != nil {
+		t.Fatal(err)
+	}
+	if !client.isBusy("doc.txt") {
+		t.Fatal("download of doc.txt was not queued")
+	}
+	pair.writeLocal("doc.txt", "local edit")
+	release()
+	pair.waitIdle(client)
+
+	if local, _ := pair.readLocal("doc.txt"); local != "cloud v2" {
+		t.Errorf("doc.txt holds %q, want the cloud copy", local)
+	}
+	if copies := pair.conflictCopies("doc.txt"); len(copies) != 1 || copies[0] != "local edit" {
+		t.Errorf("conflict copies %q, want the local edit", copies)
+	}
+}
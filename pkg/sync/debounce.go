@@ -0,0 +1,101 @@
+package sync
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// eventQuietPeriod is how long a path has to go without filesystem events
+// before it is looked at. A file is only synced once it also kept its size
+// and modification time over a whole quiet period, so a file that is still
+// being written is not uploaded halfway.
+const eventQuietPeriod = time.Second
+
+type pendingPath struct {
+	timer   *time.Timer
+	checked bool
+	size    int64
+	modTime time.Time
+}
+
+// eventDebouncer coalesces the filesystem events of every path and hands the
+// path on once it settled.
+type eventDebouncer struct {
+	mu      sync.Mutex
+	quiet   time.Duration
+	pending map[string]*pendingPath
+	settled func(key string)
+	stopped bool
+}
+
+func newEventDebouncer(quiet time.Duration, settled func(key string)) *eventDebouncer {
+	return &eventDebouncer{
+		quiet:   quiet,
+		pending: map[string]*pendingPath{},
+		settled: settled,
+	}
+}
+
+// Touch records an event for key, whose local file is at path, and restarts
+// its quiet period.
+func (debouncer *eventDebouncer) Touch(key string, path string) {
+	debouncer.mu.Lock()
+	defer debouncer.mu.Unlock()
+	if debouncer.stopped {
+		return
+	}
+	if pending, ok := debouncer.pending[key]; ok {
+		pending.checked = false
+		pending.timer.Reset(debouncer.quiet)
+		return
+	}
+	debouncer.pending[key] = &pendingPath{
+		timer: time.AfterFunc(debouncer.quiet, func() { debouncer.check(key, path) }),
+	}
+}
+
+// Stop forgets the pending paths, none of them is handed on anymore.
+func (debouncer *eventDebouncer) Stop() {
+	debouncer.mu.Lock()
+	defer debouncer.mu.Unlock()
+	debouncer.stopped = true
+	for key, pending := range debouncer.pending {
+		pending.timer.Stop()
+		delete(debouncer.pending, key)
+	}
+}
+
+// IsPending reports whether key changed recently and has not settled yet.
+func (debouncer *eventDebouncer) IsPending(key string) bool {
+	debouncer.mu.Lock()
+	defer debouncer.mu.Unlock()
+	_, ok := debouncer.pending[key]
+	return ok
+}
+
+func (debouncer *eventDebouncer) check(key string, path string) {
+	debouncer.mu.Lock()
+	pending, ok := debouncer.pending[key]
+	if !ok || debouncer.stopped {
+		debouncer.mu.Unlock()
+		return
+	}
+	info, err := os.Stat(path)
+	if err == nil && !info.IsDir() {
+		// Writers do not always cause an event for every write, so the file
+		// itself has to look the same twice in a row.
+		stable := pending.checked && info.Size() == pending.size && info.ModTime().Equal(pending.modTime)
+		if !stable {
+			pending.checked = true
+			pending.size = info.Size()
+			pending.modTime = info.ModTime()
+			pending.timer.Reset(debouncer.quiet)
+			debouncer.mu.Unlock()
+			return
+		}
+	}
+	delete(debouncer.pending, key)
+	debouncer.mu.Unlock()
+	debouncer.settled(key)
+}
@@ -0,0 +1,106 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// settledKeys collects the keys a debouncer hands on.
+type settledKeys struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+func (settled *settledKeys) add(key string) {
+	settled.mu.Lock()
+	defer settled.mu.Unlock()
+	settled.keys = append(settled.keys, key)
+}
+
+func (settled *settledKeys) count() int {
+	settled.mu.Lock()
+	defer settled.mu.Unlock()
+	return len(settled.keys)
+}
+
+func appendTo(t *testing.T, path string, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDebouncerCoalescesEvents(t *testing.T) {
+	const quiet = 50 * time.Millisecond
+	path := filepath.Join(t.TempDir(), "a.txt")
+	settled := &settledKeys{}
+	debouncer := newEventDebouncer(quiet, settled.add)
+	defer debouncer.Stop()
+
+	for i := 0; i < 10; i++ {
+		appendTo(t, path, "x")
+		debouncer.Touch("a.txt", path)
+		time.Sleep(quiet / 5)
+	}
+	if !debouncer.IsPending("a.txt") || settled.count() != 0 {
+		t.Fatal("a.txt was handed on while events kept coming")
+	}
+	time.Sleep(5 * quiet)
+	if debouncer.IsPending("a.txt") || settled.count() != 1 {
+		t.Fatalf("a.txt was handed on %d times, want once", settled.count())
+	}
+}
+
+func TestDebouncerWaitsForWritesToFinish(t *testing.T) {
+	const quiet = 100 * time.Millisecond
+	path := filepath.Join(t.TempDir(), "a.txt")
+	settled := &settledKeys{}
+	debouncer := newEventDebouncer(quiet, settled.add)
+	defer debouncer.Stop()
+
+	// A writer that causes a single event and keeps writing.
+	appendTo(t, path, "x")
+	debouncer.Touch("a.txt", path)
+	for i := 0; i < 10; i++ {
+		time.Sleep(quiet * 2 / 3)
+		appendTo(t, path, "x")
+	}
+	if settled.count() != 0 {
+		t.Fatal("a.txt was handed on while it was still growing")
+	}
+	time.Sleep(6 * quiet)
+	if settled.count() != 1 {
+		t.Fatalf("a.txt was handed on %d times, want once", settled.count())
+	}
+
+	// Removed paths settle after a single quiet period.
+	debouncer.Touch("gone.txt", filepath.Join(filepath.Dir(path), "gone.txt"))
+	time.Sleep(3 * quiet)
+	if settled.count() != 2 {
+		t.Fatal("removed path was not handed on")
+	}
+}
+
+func TestDebouncerStop(t *testing.T) {
+	const quiet = 20 * time.Millisecond
+	path := filepath.Join(t.TempDir(), "a.txt")
+	appendTo(t, path, "x")
+	settled := &settledKeys{}
+	debouncer := newEventDebouncer(quiet, settled.add)
+
+	debouncer.Touch("a.txt", path)
+	debouncer.Stop()
+	debouncer.Touch("b.txt", path)
+	time.Sleep(5 * quiet)
+	if settled.count() != 0 || debouncer.IsPending("a.txt") || debouncer.IsPending("b.txt") {
+		t.Fatal("stopped debouncer handed on or kept paths")
+	}
+}
@@ -0,0 +1,70 @@
+package sync
+
+import (
+	"fmt"
+	"time"
+
+	c "github.com/planetsp/k-drive/pkg/config"
+	log "github.com/planetsp/k-drive/pkg/logging"
+	s "github.com/planetsp/k-drive/pkg/models"
+	"github.com/planetsp/k-drive/pkg/storage"
+)
+
+// directAction narrows the two-way action reconcileFile decided on down to
+// what the sync direction of the pair allows.
+func directAction(direction string, action syncAction, local *localFile, cloud *storage.ObjectInfo) syncAction {
+	switch direction {
+	case c.DirectionUploadOnly:
+		// A backup: the cloud follows the local copy, but nothing is ever
+		// downloaded or deleted, not even in the cloud.
+		switch action {
+		case actionDownload:
+			if local != nil {
+				// Changed in the cloud behind our back, restore the backup.
+				return actionUpload
+			}
+			return actionNone
+		case actionDeleteLocal, actionConflict:
+			return actionUpload
+		case actionDeleteCloud:
+			return actionForget
+		}
+	case c.DirectionDownloadOnly:
+		// A mirror: the local copy follows the cloud, local edits are
+		// reverted, files that exist only locally are flagged.
+		switch action {
+		case actionUpload, actionConflict:
+			if cloud == nil {
+				return actionFlag
+			}
+			return actionDownload
+		case actionDeleteCloud:
+			return actionDownload
+		}
+	}
+	return action
+}
+
+// downloadOnlyReason is why local-only files of a download-only pair are
+// not synced.
+var downloadOnlyReason = fmt.Sprintf("not uploaded, this sync pair is %s", c.DirectionDownloadOnly)
+
+// flagLocalChange reports a local file that is not synced for reason, once
+// for every version of the file.
+func (client *SyncClient) flagLocalChange(key string, local *localFile, reason string) {
+	client.mu.Lock()
+	flaggedAt, ok := client.flagged[key]
+	client.flagged[key] = local.ModTime
+	client.mu.Unlock()
+	if ok && flaggedAt.Equal(local.ModTime) {
+		return
+	}
+	log.Info("%q only exists locally and is %s", key, reason)
+	client.report(&s.SyncInfo{
+		Filename:     key,
+		DateModified: time.Now(),
+		Location:     s.Local,
+		SyncStatus:   s.Error,
+		Reason:       reason,
+	})
+}
@@ -0,0 +1,69 @@
+package sync
+
+import (
+	"testing"
+	"time"
+
+	c "github.com/planetsp/k-drive/pkg/config"
+	"github.com/planetsp/k-drive/pkg/storage"
+)
+
+func TestDirectAction(t *testing.T) {
+	const (
+		anyCopy = iota
+		withLocal
+		withoutLocal
+		withCloud
+		withoutCloud
+	)
+	// Everything not listed is passed on unchanged.
+	narrowed := []struct {
+		direction string
+		action    syncAction
+		copies    int
+		want      syncAction
+	}{
+		{c.DirectionUploadOnly, actionDownload, withLocal, actionUpload},
+		{c.DirectionUploadOnly, actionDownload, withoutLocal, actionNone},
+		{c.DirectionUploadOnly, actionDeleteLocal, anyCopy, actionUpload},
+		{c.DirectionUploadOnly, actionConflict, anyCopy, actionUpload},
+		{c.DirectionUploadOnly, actionDeleteCloud, anyCopy, actionForget},
+		{c.DirectionDownloadOnly, actionUpload, withoutCloud, actionFlag},
+		{c.DirectionDownloadOnly, actionUpload, withCloud, actionDownload},
+		{c.DirectionDownloadOnly, actionConflict, withoutCloud, actionFlag},
+		{c.DirectionDownloadOnly, actionConflict, withCloud, actionDownload},
+		{c.DirectionDownloadOnly, actionDeleteCloud, anyCopy, actionDownload},
+	}
+
+	directions := []string{"", c.DirectionTwoWay, c.DirectionUploadOnly, c.DirectionDownloadOnly}
+	for _, direction := range directions {
+		for action := actionNone; action <= actionFlag; action++ {
+			for _, hasLocal := range []bool{false, true} {
+				for _, hasCloud := range []bool{false, true} {
+					var local *localFile
+					if hasLocal {
+						local = &localFile{Size: 1, ModTime: time.Now()}
+					}
+					var cloud *storage.ObjectInfo
+					if hasCloud {
+						cloud = &storage.ObjectInfo{Key: "a.txt", Size: 1}
+					}
+					want := action
+					for _, row := range narrowed {
+						matches := row.copies == anyCopy ||
+							row.copies == withLocal && hasLocal || row.copies == withoutLocal && !hasLocal ||
+							row.copies == withCloud && hasCloud || row.copies == withoutCloud && !hasCloud
+						if row.direction == direction && row.action == action && matches {
+							want = row.want
+							break
+						}
+					}
+					if got := directAction(direction, action, local, cloud); got != want {
+						t.Errorf("%q, %s with local copy %v and cloud copy %v = %s, want %s",
+							direction, action, hasLocal, hasCloud, got, want)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package sync
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/planetsp/k-drive/pkg/logging"
+	"github.com/planetsp/k-drive/pkg/storage"
+)
+
+const (
+	// downloadTempPrefix marks partial downloads in the working directory.
+	// They are never synced and are replaced atomically once complete.
+	downloadTempPrefix = ".kdrive-download-"
+
+	defaultChunkSize        = 16 * 1024 * 1024
+	defaultChunkConcurrency = 4
+)
+
+func isDownloadTempFile(name string) bool {
+	return strings.HasPrefix(filepath.Base(name), downloadTempPrefix)
+}
+
+// errChecksumMismatch means downloaded content differs from what was
+// uploaded, it is never moved into place.
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// downloadToFile fetches key into localPath without ever exposing a partial
+// file there: the content is streamed into a temporary file next to it,
+// checked against its size and the SHA-256 stored when it was uploaded, and
+// renamed over localPath. It returns what was downloaded and the SHA-256 of
+// the content. A local copy that is neither expected nor in sync with the
+// state was edited while the download was queued or running, it is moved
+// aside as a conflict copy rather than replaced.
+//
+// Large objects are fetched in parallel chunks when the backend supports
+// ranged reads. Those downloads are journaled and an interrupted one keeps
+// its temporary file, so it continues with the missing chunks next time.
+func (client *SyncClient) downloadToFile(ctx context.Context, key string, localPath string, expected *localFile) (*storage.ObjectInfo, string, error) {
+	var info *storage.ObjectInfo
+	var tmpPath, hash string
+	var err error
+	rangeGetter, canRange := client.backend.(storage.RangeGetter)
+	if canRange {
+		info, err = client.backend.Stat(ctx, key)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if canRange && info.Size > client.chunkSize {
+		tmpPath, err = client.downloadChunked(ctx, rangeGetter, info, localPath)
+	} else {
+		info, tmpPath, hash, err = client.downloadStream(ctx, key, localPath)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	complete := false
+	defer func() {
+		if !complete {
+			os.Remove(tmpPath)
+			client.forgetTransfer(key)
+		}
+	}()
+	if stat, err := os.Stat(tmpPath); err != nil {
+		return nil, "", err
+	} else if stat.Size() != info.Size {
+		return nil, "", fmt.Errorf("incomplete download of %q: got %d of %d bytes", key, stat.Size(), info.Size)
+	}
+	if hash == "" {
+		if hash, err = hashFile(tmpPath); err != nil {
+			return nil, "", err
+		}
+	}
+	if expected := info.Metadata[storage.MetadataSHA256]; expected != "" && expected != hash {
+		return nil, "", fmt.Errorf("%w: downloaded content of %q has SHA-256 %s, expected %s", errChecksumMismatch, key, hash, expected)
+	}
+
+	// Keep the cloud modification time so the local copy shows when the
+	// content was actually last changed.
+	if !info.LastModified.IsZero() {
+		os.Chtimes(tmpPath, time.Now(), info.LastModified)
+	}
+	replace, err := client.mayReplaceLocal(key, localPath, expected)
+	if err != nil {
+		return nil, "", err
+	}
+	if !replace {
+		log.Info("%q changed locally while it was downloaded", key)
+		if err := client.moveConflictCopy(key); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := os.Rename(tmpPath, localPath); err != nil {
+		return nil, "", err
+	}
+	complete = true
+	client.forgetTransfer(key)
+	return info, hash, nil
+}
+
+// mayReplaceLocal reports whether a download may replace the local copy of
+// key: there is none, it is still the expected one or it is in sync with the
+// state.
+func (client *SyncClient) mayReplaceLocal(key string, localPath string, expected *localFile) (bool, error) {
+	current, err := statLocalFile(localPath)
+	if err != nil || current == nil {
+		return err == nil, err
+	}
+	if expected != nil && current.Size == expected.Size && current.ModTime.Equal(expected.ModTime) {
+		return true, nil
+	}
+	changed, err := client.localChangedSinceSync(key, localPath, current)
+	return !changed, err
+}
+
+// downloadStream copies key into a new temporary file in one request and
+// hashes it on the way.
+func (client *SyncClient) downloadStream(ctx context.Context, key string, localPath string) (*storage.ObjectInfo, string, string, error) {
+	tmp, err := createDownloadTempFile(localPath)
+	if err != nil {
+		return nil, "", "", err
+	}
+	body, info, err := client.backend.Get(ctx, key)
+	if err == nil {
+		hasher := sha256.New()
+		_, err = io.Copy(io.MultiWriter(tmp, hasher), body)
+		body.Close()
+		if err == nil {
+			err = syncAndClose(tmp)
+		}
+		if err == nil {
+			return info, tmp.Name(), hashString(hasher), nil
+		}
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+	return nil, "", "", err
+}
+
+// downloadChunked fills a temporary file with the object described by info
+// using parallel ranged reads, recording each finished chunk in the journal.
+func (client *SyncClient) downloadChunked(ctx context.Context, rangeGetter storage.RangeGetter, info *storage.ObjectInfo, localPath string) (string, error) {
+	f, done, err := client.openDownload(info, localPath)
+	if err != nil {
+		return "", err
+	}
+	err = client.downloadChunks(ctx, rangeGetter, info, f, done)
+	if err == nil {
+		err = syncAndClose(f)
+	} else {
+		f.Close()
+	}
+	return f.Name(), err
+}
+
+func createDownloadTempFile(localPath string) (*os.File, error) {
+	return ioutil.TempFile(filepath.Dir(localPath), downloadTempPrefix)
+}
+
+func syncAndClose(f *os.File) error {
+	err := f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// downloadChunks fills f with the chunks of the object described by info
+// that are not done yet, using parallel ranged reads.
+func (client *SyncClient) downloadChunks(ctx context.Context, rangeGetter storage.RangeGetter, info *storage.ObjectInfo, f *os.File, done map[int64]bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	offsets := make(chan int64)
+	errs := make(chan error, client.chunkConcurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < client.chunkConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for offset := range offsets {
+				length := client.chunkSize
+				if info.Size-offset < length {
+					length = info.Size - offset
+				}
+				err := downloadChunk(ctx, rangeGetter, info, f, offset, length)
+				if err == nil {
+					// Only journal chunks that are safely on disk
+					err = f.Sync()
+				}
+				if err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+				if err := client.journal.AddChunk(info.Key, offset); err != nil {
+					log.Error(fmt.Sprintf("failed to journal download of %q, %v", info.Key, err))
+				}
+			}
+		}()
+	}
+
+	for offset := int64(0); offset < info.Size; offset += client.chunkSize {
+		if done[offset] {
+			continue
+		}
+		select {
+		case offsets <- offset:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(offsets)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return ctx.Err()
+	}
+}
+
+func downloadChunk(ctx context.Context, rangeGetter storage.RangeGetter, info *storage.ObjectInfo, f *os.File, offset int64, length int64) error {
+	body, err := rangeGetter.GetRange(ctx, info.Key, info.ETag, offset, length)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	written, err := io.Copy(&offsetWriter{f: f, offset: offset}, io.LimitReader(body, length))
+	if err == nil && written != length {
+		err = fmt.Errorf("short read of %q at offset %d: got %d of %d bytes", info.Key, offset, written, length)
+	}
+	return err
+}
+
+// offsetWriter writes sequentially into f starting at offset, so chunks can
+// be written concurrently without sharing a file position.
+type offsetWriter struct {
+	f      *os.File
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
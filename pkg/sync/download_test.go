@@ -0,0 +1,158 @@
+package sync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	s "github.com/planetsp/k-drive/pkg/models"
+	"github.com/planetsp/k-drive/pkg/storage"
+)
+
+func TestCorruptDownloadIsRejected(t *testing.T) {
+	for name, chunkSize := range map[string]int64{"streamed": defaultChunkSize, "chunked": 2} {
+		t.Run(name, func(t *testing.T) {
+			cloudDir := t.TempDir()
+			backend, err := storage.NewLocalBackend(cloudDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pair := newTestPair(t, backend)
+			pair.writeLocal("a.txt", "hello")
+			client := pair.client()
+			client.chunkSize = chunkSize
+			pair.reconcile(client)
+
+			// Another client uploads a new version, which is damaged in
+			// storage afterwards without its modification time changing.
+			sum := sha256.Sum256([]byte("world"))
+			metadata := map[string]string{storage.MetadataSHA256: hex.EncodeToString(sum[:])}
+			put, err := backend.Put(context.Background(), "a.txt", strings.NewReader("world"), 5, metadata)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored := filepath.Join(cloudDir, "a.txt")
+			if err := ioutil.WriteFile(stored, []byte("wXrld"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(stored, put.LastModified, put.LastModified); err != nil {
+				t.Fatal(err)
+			}
+
+			infos := make(chan *s.SyncInfo, 100)
+			client.syncInfoChannel = infos
+			pair.reconcile(client)
+			var reported *s.SyncInfo
+			for len(infos) > 0 {
+				if info := <-infos; info.SyncStatus == s.Error {
+					reported = info
+				}
+			}
+			if reported == nil || reported.Filename != "a.txt" || !strings.Contains(reported.Reason, "checksum mismatch") {
+				t.Fatalf("reported %+v, want the checksum mismatch of a.txt", reported)
+			}
+			if local, _ := pair.readLocal("a.txt"); local != "hello" {
+				t.Fatalf("local copy holds %q after a corrupt download", local)
+			}
+			files, err := ioutil.ReadDir(pair.work)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, file := range files {
+				if isDownloadTempFile(file.Name()) {
+					t.Fatalf("corrupt download left %s behind", file.Name())
+				}
+			}
+		})
+	}
+}
+
+// rangeRecorder records the ranged reads of a backend and fails the one at
+// failAt once.
+type rangeRecorder struct {
+	storage.StorageBackend
+	mu      sync.Mutex
+	failAt  int64
+	offsets []int64
+}
+
+func (backend *rangeRecorder) GetRange(ctx context.Context, key string, etag string, offset int64, length int64) (io.ReadCloser, error) {
+	backend.mu.Lock()
+	fail := offset == backend.failAt
+	if fail {
+		backend.failAt = -1
+	} else {
+		backend.offsets = append(backend.offsets, offset)
+	}
+	backend.mu.Unlock()
+	if fail {
+		return nil, errors.New("connection lost")
+	}
+	return backend.StorageBackend.(storage.RangeGetter).GetRange(ctx, key, etag, offset, length)
+}
+
+func (backend *rangeRecorder) read() []int64 {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	offsets := backend.offsets
+	backend.offsets = nil
+	return offsets
+}
+
+func TestInterruptedChunkedDownloadResumes(t *testing.T) {
+	pair := newLocalTestPair(t)
+	backend := &rangeRecorder{StorageBackend: pair.backend, failAt: 8}
+	pair.backend = backend
+	pair.putCloud("a.txt", "0123456789abcdef")
+	client := pair.client()
+	client.chunkSize = 4
+	client.chunkConcurrency = 1
+
+	if err := client.DownloadFileFromCloud("a.txt"); err == nil {
+		t.Fatal("interrupted download succeeded")
+	}
+	if _, ok := pair.readLocal("a.txt"); ok {
+		t.Fatal("partial download was moved into place")
+	}
+	transfer := client.journal.Get("a.txt")
+	if transfer == nil || !reflect.DeepEqual(transfer.Chunks, []int64{0, 4}) {
+		t.Fatalf("journaled %+v, want the chunks at 0 and 4", transfer)
+	}
+	partial, err := ioutil.ReadFile(transfer.TempPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isDownloadTempFile(transfer.TempPath) || !strings.HasPrefix(string(partial), "01234567") {
+		t.Fatalf("temporary file %s holds %q", transfer.TempPath, partial)
+	}
+	backend.read()
+
+	// A restarted client continues with the missing chunks.
+	client = pair.client()
+	client.chunkSize = 4
+	client.chunkConcurrency = 1
+	if err := client.DownloadFileFromCloud("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if offsets := backend.read(); !reflect.DeepEqual(offsets, []int64{8, 12}) {
+		t.Fatalf("resumed download read the chunks at %v, want 8 and 12", offsets)
+	}
+	if local, _ := pair.readLocal("a.txt"); local != "0123456789abcdef" {
+		t.Fatalf("resumed download holds %q", local)
+	}
+	if _, err := os.Stat(transfer.TempPath); !os.IsNotExist(err) {
+		t.Fatal("temporary file was left behind")
+	}
+	if client.journal.Get("a.txt") != nil {
+		t.Fatal("finished download is still journaled")
+	}
+}
@@ -0,0 +1,41 @@
+package sync
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/planetsp/k-drive/pkg/ignore"
+	log "github.com/planetsp/k-drive/pkg/logging"
+	"github.com/planetsp/k-drive/pkg/storage"
+)
+
+// loadIgnoreRules (re)reads the .kdriveignore of the working directory on top
+// of the patterns from the configuration.
+func (client *SyncClient) loadIgnoreRules() {
+	rules, err := ignore.Load(filepath.Join(client.workingDirectory, ignore.Filename), client.ignorePatterns)
+	if err != nil {
+		log.Error(fmt.Sprintf("failed to read %s, %v", ignore.Filename, err))
+	}
+	client.mu.Lock()
+	client.ignoreRules = rules
+	client.mu.Unlock()
+}
+
+// isIgnored reports whether key is excluded from syncing in both directions.
+// Besides the ignore rules that is the key reserved for the parameters of
+// encrypted backends.
+func (client *SyncClient) isIgnored(key string, isDir bool) bool {
+	if key == storage.EncryptionParamsKey {
+		return true
+	}
+	client.mu.Lock()
+	rules := client.ignoreRules
+	client.mu.Unlock()
+	return rules.Match(key, isDir)
+}
+
+// isIgnoredPath is isIgnored for a path below the working directory.
+func (client *SyncClient) isIgnoredPath(path string, isDir bool) bool {
+	key, err := KeyForLocalPath(client.workingDirectory, path)
+	return err == nil && key != "" && client.isIgnored(key, isDir)
+}
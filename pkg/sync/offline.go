@@ -0,0 +1,103 @@
+package sync
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/planetsp/k-drive/pkg/logging"
+)
+
+const (
+	probeMinInterval = 5 * time.Second
+	probeMaxInterval = time.Minute
+)
+
+var connectionHandler func(pairID string, online bool)
+
+// SetConnectionHandler sets a function that is told whenever the sync client
+// of a sync pair loses or regains its connection to the cloud.
+func SetConnectionHandler(handler func(pairID string, online bool)) {
+	connectionHandler = handler
+}
+
+func (client *SyncClient) isOnline() bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.online
+}
+
+func (client *SyncClient) setOnline(online bool) {
+	client.mu.Lock()
+	changed := client.online != online
+	client.online = online
+	client.mu.Unlock()
+	if !changed {
+		return
+	}
+	if online {
+		log.Info("%s is reachable, syncing", client.backend.Name())
+	} else {
+		log.Info("%s is unreachable, queueing local changes until it is back", client.backend.Name())
+	}
+	if connectionHandler != nil {
+		connectionHandler(client.pairID, online)
+	}
+}
+
+// waitUntilOnline probes the backend, less often the longer it stays down,
+// until it answers. It returns false if the client is stopped meanwhile.
+func (client *SyncClient) waitUntilOnline() bool {
+	interval := probeMinInterval
+	for {
+		if err := client.backend.CheckConnection(client.ctx); err == nil {
+			client.setOnline(true)
+			return true
+		}
+		if client.ctx.Err() != nil {
+			return false
+		}
+		client.setOnline(false)
+		select {
+		case <-time.After(interval):
+		case <-client.ctx.Done():
+			return false
+		}
+		if interval *= 2; interval > probeMaxInterval {
+			interval = probeMaxInterval
+		}
+	}
+}
+
+// handleLocalChange syncs a changed local path right away, or queues it
+// while the cloud cannot be reached.
+func (client *SyncClient) handleLocalChange(key string) {
+	if client.ctx.Err() != nil {
+		return
+	}
+	if !client.isOnline() {
+		client.queueChange(key)
+		return
+	}
+	client.reconcilePath(key)
+}
+
+func (client *SyncClient) queueChange(key string) {
+	if err := client.queue.Add(key); err != nil {
+		log.Error(fmt.Sprintf("failed to queue change of %q, %v", key, err))
+	}
+}
+
+// replayQueue syncs the paths that changed while offline, including those
+// queued before the last restart.
+func (client *SyncClient) replayQueue() {
+	keys, err := client.queue.Drain()
+	if err != nil {
+		log.Error(fmt.Sprintf("failed to update change queue, %v", err))
+	}
+	if len(keys) > 0 {
+		log.Info("syncing %d changes made while offline", len(keys))
+	}
+	for _, key := range keys {
+		client.reconcilePath(key)
+	}
+}
@@ -0,0 +1,132 @@
+package sync
+
+import (
+	"context"
+	"io"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/planetsp/k-drive/pkg/storage"
+)
+
+// unreachableBackend refuses every request while it is down and counts the
+// uploads of each key.
+type unreachableBackend struct {
+	storage.StorageBackend
+	mu   sync.Mutex
+	down bool
+	puts map[string]int
+}
+
+func (backend *unreachableBackend) setDown(down bool) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	backend.down = down
+}
+
+func (backend *unreachableBackend) check() error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	if backend.down {
+		return syscall.ECONNREFUSED
+	}
+	return nil
+}
+
+func (backend *unreachableBackend) uploads(key string) int {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	return backend.puts[key]
+}
+
+func (backend *unreachableBackend) CheckConnection(ctx context.Context) error {
+	if err := backend.check(); err != nil {
+		return err
+	}
+	return backend.StorageBackend.CheckConnection(ctx)
+}
+
+func (backend *unreachableBackend) List(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	if err := backend.check(); err != nil {
+		return nil, err
+	}
+	return backend.StorageBackend.List(ctx, prefix)
+}
+
+func (backend *unreachableBackend) Stat(ctx context.Context, key string) (*storage.ObjectInfo, error) {
+	if err := backend.check(); err != nil {
+		return nil, err
+	}
+	return backend.StorageBackend.Stat(ctx, key)
+}
+
+func (backend *unreachableBackend) Get(ctx context.Context, key string) (io.ReadCloser, *storage.ObjectInfo, error) {
+	if err := backend.check(); err != nil {
+		return nil, nil, err
+	}
+	return backend.StorageBackend.Get(ctx, key)
+}
+
+func (backend *unreachableBackend) Put(ctx context.Context, key string, body io.Reader, size int64, metadata map[string]string) (*storage.ObjectInfo, error) {
+	if err := backend.check(); err != nil {
+		return nil, err
+	}
+	backend.mu.Lock()
+	backend.puts[key]++
+	backend.mu.Unlock()
+	return backend.StorageBackend.Put(ctx, key, body, size, metadata)
+}
+
+func (backend *unreachableBackend) Delete(ctx context.Context, key string) error {
+	if err := backend.check(); err != nil {
+		return err
+	}
+	return backend.StorageBackend.Delete(ctx, key)
+}
+
+func TestOfflineChangesAreUploadedOnceBackOnline(t *testing.T) {
+	pair := newLocalTestPair(t)
+	backend := &unreachableBackend{StorageBackend: pair.backend, puts: map[string]int{}}
+	pair.backend = backend
+	pair.writeLocal("edited.txt", "before")
+	client := pair.client()
+	pair.reconcile(client)
+
+	// What the cloud monitor does once a reconcile fails.
+	backend.setDown(true)
+	client.setOnline(false)
+	pair.writeLocal("edited.txt", "after")
+	pair.writeLocal("new.txt", "new")
+	// Editors often save a file more than once.
+	for _, key := range []string{"edited.txt", "new.txt", "new.txt"} {
+		client.handleLocalChange(key)
+	}
+	pair.waitIdle(client)
+	if queued := client.queue.Len(); queued != 2 {
+		t.Fatalf("%d changes queued while offline, want 2", queued)
+	}
+
+	backend.setDown(false)
+	if !client.waitUntilOnline() {
+		t.Fatal("client did not come back online")
+	}
+	client.replayQueue()
+	pair.waitIdle(client)
+	pair.reconcile(client)
+
+	for key, want := range map[string]string{"edited.txt": "after", "new.txt": "new"} {
+		if cloud, _ := pair.readCloud(key); cloud != want {
+			t.Errorf("cloud copy of %s holds %q, want %q", key, cloud, want)
+		}
+	}
+	if uploads := backend.uploads("edited.txt"); uploads != 2 {
+		t.Errorf("edited.txt was uploaded %d times, want once before and once after going offline", uploads)
+	}
+	if uploads := backend.uploads("new.txt"); uploads != 1 {
+		t.Errorf("new.txt was uploaded %d times, want once", uploads)
+	}
+	if queued := client.queue.Len(); queued != 0 {
+		t.Errorf("%d changes still queued", queued)
+	}
+}
@@ -0,0 +1,503 @@
+package sync
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/planetsp/k-drive/pkg/logging"
+	s "github.com/planetsp/k-drive/pkg/models"
+	"github.com/planetsp/k-drive/pkg/state"
+	"github.com/planetsp/k-drive/pkg/storage"
+)
+
+type syncAction int
+
+const (
+	actionNone        syncAction = iota // both sides already agree
+	actionUpload      syncAction = iota // local copy must be sent to the cloud
+	actionDownload    syncAction = iota // cloud copy must be fetched
+	actionDeleteCloud syncAction = iota // deleted locally since the last sync
+	actionDeleteLocal syncAction = iota // deleted in the cloud since the last sync
+	actionRecord      syncAction = iota // present on both sides, remember it as synced
+	actionForget      syncAction = iota // gone on both sides, drop it from the state
+	actionConflict    syncAction = iota // changed on both sides since the last sync
+	actionFlag        syncAction = iota // local only, but the pair only downloads
+)
+
+func (action syncAction) String() string {
+	switch action {
+	case actionUpload:
+		return "upload"
+	case actionDownload:
+		return "download"
+	case actionDeleteCloud:
+		return "delete in cloud"
+	case actionDeleteLocal:
+		return "delete locally"
+	case actionRecord:
+		return "record"
+	case actionForget:
+		return "forget"
+	case actionConflict:
+		return "conflict"
+	case actionFlag:
+		return "flag"
+	}
+	return "none"
+}
+
+// localFile is what a scan of the working directory knows about one entry.
+type localFile struct {
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+	Hash    string // only filled in when size and time cannot decide
+	MD5     string // only filled in to compare with the ETag of a cloud copy without hash
+}
+
+// reconcileFile decides what to do with one key by comparing the local copy,
+// the cloud copy and the state recorded when the key was last in sync. Any
+// of the three may be nil.
+func reconcileFile(local *localFile, cloud *storage.ObjectInfo, known *state.FileState) syncAction {
+	switch {
+	case local == nil && cloud == nil:
+		if known != nil {
+			return actionForget
+		}
+		return actionNone
+	case local != nil && cloud != nil:
+		if known == nil {
+			// Never synced, e.g. the same files were copied to both sides.
+			// Unless the content is known to be the same both are kept.
+			if sameContent(local, cloud) {
+				return actionRecord
+			}
+			return actionConflict
+		}
+		localChanged := localFileChanged(local, known)
+		cloudChanged := cloudFileChanged(cloud, known)
+		switch {
+		case localChanged && cloudChanged:
+			return actionConflict
+		case localChanged:
+			return actionUpload
+		case cloudChanged:
+			return actionDownload
+		}
+		return actionNone
+	case local != nil:
+		if known == nil || localFileChanged(local, known) {
+			// New, or edited locally after it was deleted in the cloud
+			return actionUpload
+		}
+		return actionDeleteLocal
+	default:
+		if known == nil || cloudFileChanged(cloud, known) {
+			// New, or edited in the cloud after it was deleted locally
+			return actionDownload
+		}
+		return actionDeleteCloud
+	}
+}
+
+// localFileChanged compares the local copy with its state at the last sync.
+// A different modification time alone is not enough when the content hash
+// is available, e.g. after a file was only touched or copied back in place.
+func localFileChanged(local *localFile, known *state.FileState) bool {
+	if local.Size != known.Size {
+		return true
+	}
+	if local.ModTime.Equal(known.ModTime) {
+		return false
+	}
+	if local.Hash != "" && known.Hash != "" {
+		return local.Hash != known.Hash
+	}
+	return true
+}
+
+// localChangedSinceSync reports whether local, the copy of key at localPath,
+// differs from the state key was last synced in. Unknown keys count as
+// changed.
+func (client *SyncClient) localChangedSinceSync(key string, localPath string, local *localFile) (bool, error) {
+	known := client.state.Get(key)
+	if known == nil {
+		return true, nil
+	}
+	if needsLocalHash(local, nil, known) {
+		var err error
+		if local.Hash, err = hashFile(localPath); err != nil {
+			return false, err
+		}
+	}
+	return localFileChanged(local, known), nil
+}
+
+// cloudFileChanged compares the cloud copy with its state at the last sync.
+// Every write to an object produces a new ETag, but the content hash stored
+// with it tells whether the content actually differs, e.g. after another
+// computer uploaded the same file again.
+func cloudFileChanged(cloud *storage.ObjectInfo, known *state.FileState) bool {
+	if cloud.ETag == known.ETag {
+		return false
+	}
+	if hash := cloudHash(cloud); hash != "" && known.Hash != "" {
+		return hash != known.Hash
+	}
+	return true
+}
+
+// cloudHash returns the SHA-256 stored with cloud when it was uploaded, ""
+// if it is unknown, e.g. because cloud comes from a listing.
+func cloudHash(cloud *storage.ObjectInfo) string {
+	return cloud.Metadata[storage.MetadataSHA256]
+}
+
+// cloudMD5 returns the ETag of cloud if it looks like the MD5 of its content,
+// as it is for objects uploaded in a single part, "" otherwise.
+func cloudMD5(cloud *storage.ObjectInfo) string {
+	if len(cloud.ETag) != 32 {
+		return ""
+	}
+	if _, err := hex.DecodeString(cloud.ETag); err != nil {
+		return ""
+	}
+	return strings.ToLower(cloud.ETag)
+}
+
+// sameContent reports whether local and cloud are known to hold the same
+// content, by the SHA-256 stored with the cloud copy or, for objects stored
+// without one, by their ETag.
+func sameContent(local *localFile, cloud *storage.ObjectInfo) bool {
+	if local.Size != cloud.Size {
+		return false
+	}
+	if hash := cloudHash(cloud); hash != "" {
+		return local.Hash == hash
+	}
+	if md5 := cloudMD5(cloud); md5 != "" {
+		return local.MD5 == md5
+	}
+	return false
+}
+
+func newestWins(local *localFile, cloud *storage.ObjectInfo) syncAction {
+	if local.ModTime.After(cloud.LastModified) {
+		return actionUpload
+	}
+	return actionDownload
+}
+
+// needsLocalHash reports whether size and modification time of local are
+// inconclusive so its content hash should be compared with the state, or
+// with the cloud copy if the key was never synced.
+func needsLocalHash(local *localFile, cloud *storage.ObjectInfo, known *state.FileState) bool {
+	if local == nil || local.IsDir {
+		return false
+	}
+	if known == nil {
+		return cloud != nil && local.Size == cloud.Size
+	}
+	return known.Hash != "" && local.Size == known.Size && !local.ModTime.Equal(known.ModTime)
+}
+
+// needsCloudHash reports whether the ETag of cloud is inconclusive so the
+// content hash stored with it should be looked up.
+func needsCloudHash(local *localFile, cloud *storage.ObjectInfo, known *state.FileState) bool {
+	if cloud == nil || cloudHash(cloud) != "" || strings.HasSuffix(cloud.Key, "/") {
+		return false
+	}
+	if known == nil {
+		return local != nil && !local.IsDir && local.Size == cloud.Size
+	}
+	return known.Hash != "" && cloud.ETag != known.ETag && cloud.Size == known.Size
+}
+
+// ReconcileAll compares the whole working directory with the whole cloud and
+// the sync state, and starts whatever transfers are needed to converge.
+func (client *SyncClient) ReconcileAll() error {
+	started := time.Now()
+	cloud, err := client.listing.Objects(client.ctx, client.backend)
+	if err != nil {
+		return err
+	}
+	client.loadIgnoreRules()
+	local, err := scanLocalDir(client.workingDirectory, client.isIgnored)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]bool, len(local)+len(cloud))
+	for key := range local {
+		keys[key] = true
+	}
+	for key := range cloud {
+		keys[key] = true
+	}
+	for _, key := range client.state.Keys("") {
+		keys[key] = true
+	}
+
+	for key := range keys {
+		if err := storage.CheckKey(key); err != nil {
+			// Its local copy would end up outside the working directory.
+			client.rejectKey(key, err)
+			continue
+		}
+		isDir := strings.HasSuffix(key, "/") || local[key] != nil && local[key].IsDir
+		if client.isIgnored(key, isDir) {
+			// Ignored files are left alone on both sides.
+			continue
+		}
+		if strings.HasSuffix(key, "/") {
+			if client.isSelected(key, true) {
+				client.reconcileFolderMarker(key, local[key] != nil)
+			}
+			continue
+		}
+		if local[key] != nil && local[key].IsDir {
+			continue
+		}
+		client.apply(key, local[key], cloud[key], started)
+	}
+	return client.state.Save()
+}
+
+// reconcilePath reconciles a single key, typically after a filesystem event,
+// without listing the whole bucket.
+func (client *SyncClient) reconcilePath(key string) {
+	localPath, err := LocalPathForKey(client.workingDirectory, key)
+	if err != nil {
+		client.rejectKey(key, err)
+		return
+	}
+	started := time.Now()
+	local, err := statLocalFile(localPath)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if client.isIgnored(key, local != nil && local.IsDir) {
+		return
+	}
+	if local != nil && local.IsDir {
+		// A new or moved folder, everything inside needs looking at.
+		files, err := scanLocalDir(localPath, func(child string, isDir bool) bool {
+			return client.isIgnored(key+"/"+child, isDir)
+		})
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		for child, file := range files {
+			if !file.IsDir {
+				client.reconcilePath(key + "/" + child)
+			}
+		}
+		return
+	}
+
+	cloud, err := client.backend.Stat(client.ctx, key)
+	if err == storage.ErrNotFound {
+		cloud = nil
+		client.listing.Remove(key)
+	} else if err != nil {
+		log.Error(fmt.Sprintf("failed to look up %q in cloud, %v", key, err))
+		if storage.IsTransientError(err) {
+			client.queueChange(key)
+		}
+		return
+	} else {
+		client.listing.Put(*cloud)
+	}
+
+	if local == nil && cloud == nil && client.state.Get(key) == nil {
+		// Possibly a removed folder, whose files are only known by the state.
+		for _, child := range client.state.Keys(key + "/") {
+			client.reconcilePath(child)
+		}
+		return
+	}
+	client.apply(key, local, cloud, started)
+}
+
+// apply starts whatever is needed to converge key, given what was found
+// locally and in the cloud by looking at both sides from started on.
+func (client *SyncClient) apply(key string, local *localFile, cloud *storage.ObjectInfo, started time.Time) {
+	localPath, err := LocalPathForKey(client.workingDirectory, key)
+	if err != nil {
+		client.rejectKey(key, err)
+		return
+	}
+	if client.isBusy(key) || client.hasFailed(key) || client.events.IsPending(key) {
+		// A pending key is still being written and is looked at once it
+		// settled.
+		return
+	}
+	if !client.isSelected(key, false) && client.evictUnselected(key, local, cloud) {
+		return
+	}
+	known := client.state.Get(key)
+	if needsCloudHash(local, cloud, known) {
+		// Listings carry no metadata, ask for the object itself.
+		if stat, err := client.backend.Stat(client.ctx, key); err == nil {
+			cloud = stat
+		}
+	}
+	if needsLocalHash(local, cloud, known) {
+		if known == nil && cloudHash(cloud) == "" {
+			local.Hash, local.MD5, err = hashFileWithMD5(localPath)
+		} else {
+			local.Hash, err = hashFile(localPath)
+		}
+		if err != nil {
+			log.Error(err)
+			return
+		}
+	}
+	action := directAction(client.direction, reconcileFile(local, cloud, known), local, cloud)
+	if (action == actionDeleteLocal || action == actionDeleteCloud) && known != nil && known.SyncedAt.After(started) {
+		// Transferred while the sides were looked at, the missing copy was
+		// most likely only missed. The next pass sees it.
+		return
+	}
+	if action != actionNone {
+		log.Debug("reconcile " + key + ": " + action.String())
+	}
+
+	switch action {
+	case actionUpload:
+		client.runTransfer(key, local.Size, s.Local, client.UploadFileToCloud)
+	case actionDownload:
+		client.runTransfer(key, cloud.Size, s.Cloud, client.downloadReplacing(local))
+	case actionDeleteCloud:
+		client.runTransfer(key, 0, s.Cloud, client.DeleteFileFromCloud)
+	case actionDeleteLocal:
+		client.runTransfer(key, 0, s.Local, client.DeleteLocalFile)
+	case actionRecord:
+		hash := local.Hash
+		if hash == "" {
+			if hash, err = hashFile(localPath); err != nil {
+				log.Error(err)
+				return
+			}
+		}
+		client.state.Put(state.FileState{
+			Path:     key,
+			Size:     local.Size,
+			ModTime:  local.ModTime,
+			ETag:     cloud.ETag,
+			Hash:     hash,
+			SyncedAt: time.Now(),
+		})
+	case actionForget:
+		client.state.Delete(key)
+	case actionConflict:
+		client.resolveConflict(key, local, cloud)
+	case actionFlag:
+		client.flagLocalChange(key, local, downloadOnlyReason)
+	case actionNone:
+		if known == nil {
+			return
+		}
+		touched := local != nil && local.Hash != "" && !local.ModTime.Equal(known.ModTime)
+		reuploaded := cloud != nil && cloud.ETag != known.ETag && known.Hash != "" && cloudHash(cloud) == known.Hash
+		if touched || reuploaded {
+			// Same content as before, remember the new time and ETag so
+			// neither side is hashed again on every poll.
+			if touched {
+				known.ModTime = local.ModTime
+			}
+			if reuploaded {
+				known.ETag = cloud.ETag
+			}
+			client.state.Put(*known)
+		}
+	}
+}
+
+// reconcileFolderMarker creates local folders for folder markers in the
+// cloud. Folders are not tracked in the sync state, they come and go with
+// the files inside them.
+func (client *SyncClient) reconcileFolderMarker(key string, existsLocally bool) {
+	if existsLocally {
+		return
+	}
+	localPath, err := LocalPathForKey(client.workingDirectory, key)
+	if err != nil {
+		client.rejectKey(key, err)
+		return
+	}
+	if err := os.MkdirAll(localPath, 0755); err != nil {
+		log.Error(err)
+	}
+}
+
+// rejectKey reports a key that is not synced because its local copy would
+// end up outside the working directory, once per key.
+func (client *SyncClient) rejectKey(key string, err error) {
+	client.mu.Lock()
+	reported := client.rejected[key]
+	client.rejected[key] = true
+	client.mu.Unlock()
+	if reported {
+		return
+	}
+	log.Error(fmt.Sprintf("Not syncing %q: %v", key, err))
+	client.report(&s.SyncInfo{
+		Filename:     key,
+		DateModified: time.Now(),
+		Location:     s.Cloud,
+		SyncStatus:   s.Error,
+		Reason:       "not synced, the name leads outside of the sync folder",
+	})
+}
+
+// scanLocalDir lists everything below root by key, leaving out what skip
+// returns true for.
+func scanLocalDir(root string, skip func(key string, isDir bool) bool) (map[string]*localFile, error) {
+	files := make(map[string]*localFile)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if isDownloadTempFile(path) {
+			return nil
+		}
+		key, err := KeyForLocalPath(root, path)
+		if err != nil || key == "" {
+			return err
+		}
+		if skip != nil && skip(key, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			files[key+"/"] = &localFile{ModTime: info.ModTime(), IsDir: true}
+		} else {
+			files[key] = &localFile{Size: info.Size(), ModTime: info.ModTime()}
+		}
+		return nil
+	})
+	return files, err
+}
+
+func statLocalFile(path string) (*localFile, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &localFile{Size: info.Size(), ModTime: info.ModTime(), IsDir: info.IsDir()}, nil
+}
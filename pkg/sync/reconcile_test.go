@@ -0,0 +1,191 @@
+package sync
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/planetsp/k-drive/pkg/fakes3"
+	"github.com/planetsp/k-drive/pkg/state"
+	"github.com/planetsp/k-drive/pkg/storage"
+)
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func md5Hex(data string) string {
+	sum := md5.Sum([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestReconcileFile(t *testing.T) {
+	synced := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	later := synced.Add(time.Hour)
+	known := &state.FileState{Path: "a.txt", Size: 5, ModTime: synced, ETag: "v1", Hash: sha256Hex("hello")}
+	withHash := func(etag string, size int64, data string) *storage.ObjectInfo {
+		return &storage.ObjectInfo{Key: "a.txt", Size: size, ETag: etag, Metadata: map[string]string{storage.MetadataSHA256: sha256Hex(data)}}
+	}
+
+	tests := []struct {
+		name  string
+		local *localFile
+		cloud *storage.ObjectInfo
+		known *state.FileState
+		want  syncAction
+	}{
+		{"nothing", nil, nil, nil, actionNone},
+		{"gone on both sides", nil, nil, known, actionForget},
+		{"new locally", &localFile{Size: 5, ModTime: later}, nil, nil, actionUpload},
+		{"new in cloud", nil, &storage.ObjectInfo{Key: "a.txt", Size: 5, ETag: "v1"}, nil, actionDownload},
+		{"unchanged", &localFile{Size: 5, ModTime: synced}, &storage.ObjectInfo{Size: 5, ETag: "v1"}, known, actionNone},
+		{"edited locally", &localFile{Size: 6, ModTime: later}, &storage.ObjectInfo{Size: 5, ETag: "v1"}, known, actionUpload},
+		{"touched locally", &localFile{Size: 5, ModTime: later, Hash: sha256Hex("hello")}, &storage.ObjectInfo{Size: 5, ETag: "v1"}, known, actionNone},
+		{"edited in cloud", &localFile{Size: 5, ModTime: synced}, &storage.ObjectInfo{Size: 5, ETag: "v2"}, known, actionDownload},
+		{"uploaded again unchanged", &localFile{Size: 5, ModTime: synced}, withHash("v2", 5, "hello"), known, actionNone},
+		{"edited on both sides", &localFile{Size: 6, ModTime: later}, &storage.ObjectInfo{Size: 5, ETag: "v2"}, known, actionConflict},
+		{"deleted locally", nil, &storage.ObjectInfo{Size: 5, ETag: "v1"}, known, actionDeleteCloud},
+		{"deleted locally, edited in cloud", nil, &storage.ObjectInfo{Size: 5, ETag: "v2"}, known, actionDownload},
+		{"deleted in cloud", &localFile{Size: 5, ModTime: synced}, nil, known, actionDeleteLocal},
+		{"deleted in cloud, edited locally", &localFile{Size: 6, ModTime: later}, nil, known, actionUpload},
+
+		// Never synced before, e.g. the same folder was copied to both sides.
+		{"first sync, same hash", &localFile{Size: 5, Hash: sha256Hex("hello")}, withHash("v1", 5, "hello"), nil, actionRecord},
+		{"first sync, different hash", &localFile{Size: 5, Hash: sha256Hex("local")}, withHash("v1", 5, "cloud"), nil, actionConflict},
+		{"first sync, local not hashed", &localFile{Size: 5}, withHash("v1", 5, "hello"), nil, actionConflict},
+		{"first sync, same MD5 ETag", &localFile{Size: 5, Hash: sha256Hex("hello"), MD5: md5Hex("hello")}, &storage.ObjectInfo{Size: 5, ETag: md5Hex("hello")}, nil, actionRecord},
+		{"first sync, different MD5 ETag", &localFile{Size: 5, Hash: sha256Hex("local"), MD5: md5Hex("local")}, &storage.ObjectInfo{Size: 5, ETag: md5Hex("cloud")}, nil, actionConflict},
+		{"first sync, multipart ETag", &localFile{Size: 5, Hash: sha256Hex("hello"), MD5: md5Hex("hello")}, &storage.ObjectInfo{Size: 5, ETag: md5Hex("hello") + "-2"}, nil, actionConflict},
+		{"first sync, nothing to compare", &localFile{Size: 5, Hash: sha256Hex("hello")}, &storage.ObjectInfo{Size: 5, ETag: "v1"}, nil, actionConflict},
+		{"first sync, different size", &localFile{Size: 4}, &storage.ObjectInfo{Size: 5, ETag: "v1"}, nil, actionConflict},
+	}
+	for _, test := range tests {
+		if got := reconcileFile(test.local, test.cloud, test.known); got != test.want {
+			t.Errorf("%s: reconcileFile() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// conflictCopies returns the contents of the conflict copies of key.
+func (pair *testPair) conflictCopies(key string) []string {
+	pair.t.Helper()
+	ext := filepath.Ext(key)
+	matches, err := filepath.Glob(pair.local(strings.TrimSuffix(key, ext)) + " (conflicted copy from *)" + ext)
+	if err != nil {
+		pair.t.Fatal(err)
+	}
+	var copies []string
+	for _, match := range matches {
+		data, err := ioutil.ReadFile(match)
+		if err != nil {
+			pair.t.Fatal(err)
+		}
+		copies = append(copies, string(data))
+	}
+	return copies
+}
+
+func TestFirstSyncKeepsBothCopiesOfDifferentContent(t *testing.T) {
+	pair := newLocalTestPair(t)
+	ctx := context.Background()
+
+	// Same size, different content and nothing in the cloud to compare with.
+	pair.writeLocal("plain.txt", "local")
+	pair.putCloud("plain.txt", "cloud")
+	// Same size, different content, hash stored with the cloud copy.
+	pair.writeLocal("hashed.txt", "local")
+	_, err := pair.backend.Put(ctx, "hashed.txt", strings.NewReader("cloud"), 5, map[string]string{storage.MetadataSHA256: sha256Hex("cloud")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Same content, hash stored with the cloud copy.
+	pair.writeLocal("same.txt", "equal")
+	_, err = pair.backend.Put(ctx, "same.txt", strings.NewReader("equal"), 5, map[string]string{storage.MetadataSHA256: sha256Hex("equal")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := pair.client()
+	pair.reconcile(client)
+	for _, key := range []string{"plain.txt", "hashed.txt"} {
+		if local, _ := pair.readLocal(key); local != "cloud" {
+			t.Errorf("%s holds %q, want the cloud copy", key, local)
+		}
+		if copies := pair.conflictCopies(key); len(copies) != 1 || copies[0] != "local" {
+			t.Errorf("conflict copies of %s: %q, want the local copy", key, copies)
+		}
+	}
+	if local, _ := pair.readLocal("same.txt"); local != "equal" {
+		t.Errorf("same.txt holds %q", local)
+	}
+	if copies := pair.conflictCopies("same.txt"); len(copies) != 0 {
+		t.Errorf("identical same.txt got conflict copies %q", copies)
+	}
+	if known := client.state.Get("same.txt"); known == nil || known.Hash != sha256Hex("equal") {
+		t.Errorf("same.txt recorded as %+v", known)
+	}
+}
+
+func TestFirstSyncComparesSinglePartETags(t *testing.T) {
+	server := fakes3.NewServer("k-drive")
+	t.Cleanup(server.Close)
+	pair := newTestPair(t, storage.NewS3Backend(storage.CreateS3Client(server.S3Options), "k-drive"))
+
+	// Uploaded by another tool, without a stored hash.
+	pair.writeLocal("same.txt", "equal")
+	server.PutObject("k-drive", "same.txt", []byte("equal"), nil)
+	pair.writeLocal("different.txt", "local")
+	server.PutObject("k-drive", "different.txt", []byte("cloud"), nil)
+
+	client := pair.client()
+	pair.reconcile(client)
+	if copies := pair.conflictCopies("same.txt"); len(copies) != 0 {
+		t.Errorf("identical same.txt got conflict copies %q", copies)
+	}
+	if client.state.Get("same.txt") == nil {
+		t.Error("same.txt was not recorded as synced")
+	}
+	if local, _ := pair.readLocal("different.txt"); local != "cloud" {
+		t.Errorf("different.txt holds %q, want the cloud copy", local)
+	}
+	if copies := pair.conflictCopies("different.txt"); len(copies) != 1 || copies[0] != "local" {
+		t.Errorf("conflict copies of different.txt: %q, want the local copy", copies)
+	}
+}
+
+func TestQueuedLocalDeleteKeepsLocalEdits(t *testing.T) {
+	pair := newLocalTestPair(t)
+	pair.config.TransferWorkers = 1
+	pair.writeLocal("doc.txt", "v1")
+	client := pair.client()
+	pair.reconcile(client)
+
+	// Deleted in the cloud and edited locally while the delete is queued.
+	release := pair.blockWorker(client)
+	if err := pair.backend.Delete(context.Background(), "doc.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ReconcileAll(); err != nil {
+		t.Fatal(err)
+	}
+	if !client.isBusy("doc.txt") {
+		t.Fatal("local delete of doc.txt was not queued")
+	}
+	pair.writeLocal("doc.txt", "edited locally")
+	release()
+	pair.waitIdle(client)
+
+	if local, _ := pair.readLocal("doc.txt"); local != "edited locally" {
+		t.Fatalf("doc.txt holds %q after the queued delete", local)
+	}
+	if cloud, _ := pair.readCloud("doc.txt"); cloud != "edited locally" {
+		t.Fatalf("cloud copy of doc.txt holds %q, want the local edit", cloud)
+	}
+}
@@ -0,0 +1,147 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	log "github.com/planetsp/k-drive/pkg/logging"
+	s "github.com/planetsp/k-drive/pkg/models"
+	"github.com/planetsp/k-drive/pkg/storage"
+)
+
+const (
+	// maxTransferAttempts bounds the retries of a transient failure, after
+	// that the key is reported as failed like a permanent error.
+	maxTransferAttempts = 6
+	retryBaseDelay      = time.Second
+	retryMaxDelay       = 5 * time.Minute
+	// failedRetryInterval is how long a failed key is left alone before the
+	// next reconcile tries it again on its own.
+	failedRetryInterval = 10 * time.Minute
+)
+
+// transferDone is called by the scheduler for every finished job. Transient
+// failures are queued again after a backoff, everything else ends the job.
+func (client *SyncClient) transferDone(job *transferJob, err error) {
+	if err == nil {
+		client.mu.Lock()
+		delete(client.failed, job.key)
+		delete(client.busy, job.key)
+		client.mu.Unlock()
+		return
+	}
+
+	if client.ctx.Err() != nil {
+		// Stopped, the journal knows what to continue on the next start.
+		client.mu.Lock()
+		delete(client.busy, job.key)
+		client.mu.Unlock()
+		return
+	}
+
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrChanged) || errors.Is(err, os.ErrNotExist) {
+		// The file went away or changed on one side meanwhile, the next
+		// reconcile decides what that means.
+		log.Info("%v, leaving it to the next reconcile", err)
+		client.mu.Lock()
+		delete(client.busy, job.key)
+		client.mu.Unlock()
+		return
+	}
+
+	job.attempts++
+	if storage.IsTransientError(err) && !client.isOnline() {
+		client.parkOffline(job)
+		return
+	}
+	if storage.IsTransientError(err) && job.attempts < maxTransferAttempts {
+		delay := retryDelay(job.attempts)
+		log.Info("%v, retrying in %s", err, delay.Round(time.Second))
+		client.mu.Lock()
+		client.retrying[job] = time.AfterFunc(delay, func() {
+			client.mu.Lock()
+			delete(client.retrying, job)
+			client.mu.Unlock()
+			if !client.isOnline() {
+				client.parkOffline(job)
+				return
+			}
+			client.scheduler.Requeue(job)
+		})
+		client.mu.Unlock()
+		return
+	}
+
+	log.Error(err)
+	client.mu.Lock()
+	client.failed[job.key] = time.Now()
+	delete(client.busy, job.key)
+	client.mu.Unlock()
+	client.report(&s.SyncInfo{
+		Filename:     job.key,
+		DateModified: time.Now(),
+		Location:     job.from,
+		SyncStatus:   s.Error,
+		Reason:       errorReason(err, job.attempts),
+	})
+}
+
+// parkOffline gives up on job while the cloud is unreachable, its key is
+// synced again from the change queue once the connection is back.
+func (client *SyncClient) parkOffline(job *transferJob) {
+	client.queueChange(job.key)
+	client.mu.Lock()
+	delete(client.busy, job.key)
+	client.mu.Unlock()
+}
+
+// retryDelay doubles the wait with every attempt and picks a random point in
+// its upper half, so many files failing together do not retry in lockstep.
+func retryDelay(attempt int) time.Duration {
+	delay := retryBaseDelay << uint(attempt-1)
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func errorReason(err error, attempts int) string {
+	if storage.IsTransientError(err) {
+		return fmt.Sprintf("%v (gave up after %d attempts)", err, attempts)
+	}
+	return err.Error()
+}
+
+// hasFailed reports whether the last transfer of key gave up recently, so
+// reconciling does not start it over and over.
+func (client *SyncClient) hasFailed(key string) bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	failedAt, ok := client.failed[key]
+	return ok && time.Since(failedAt) < failedRetryInterval
+}
+
+// RetryNow forgets about failed transfers, runs the ones waiting for their
+// backoff right away and reconciles everything again.
+func (client *SyncClient) RetryNow() {
+	client.mu.Lock()
+	client.failed = map[string]time.Time{}
+	waiting := []*transferJob{}
+	for job, timer := range client.retrying {
+		if timer.Stop() {
+			waiting = append(waiting, job)
+		}
+		delete(client.retrying, job)
+	}
+	client.mu.Unlock()
+
+	for _, job := range waiting {
+		client.scheduler.Requeue(job)
+	}
+	if err := client.ReconcileAll(); err != nil {
+		log.Error(fmt.Sprintf("Failed to reconcile with cloud: %v", err))
+	}
+}
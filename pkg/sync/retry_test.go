@@ -0,0 +1,118 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	s "github.com/planetsp/k-drive/pkg/models"
+	"github.com/planetsp/k-drive/pkg/storage"
+)
+
+// flakyBackend fails the next uploads with err.
+type flakyBackend struct {
+	storage.StorageBackend
+	mu    sync.Mutex
+	fails int
+	err   error
+}
+
+func (backend *flakyBackend) Put(ctx context.Context, key string, body io.Reader, size int64, metadata map[string]string) (*storage.ObjectInfo, error) {
+	backend.mu.Lock()
+	fail := backend.fails > 0
+	if fail {
+		backend.fails--
+	}
+	backend.mu.Unlock()
+	if fail {
+		return nil, backend.err
+	}
+	return backend.StorageBackend.Put(ctx, key, body, size, metadata)
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempt := 1; attempt <= 20; attempt++ {
+		ceiling := retryBaseDelay << uint(attempt-1)
+		if ceiling > retryMaxDelay {
+			ceiling = retryMaxDelay
+		}
+		for i := 0; i < 100; i++ {
+			if delay := retryDelay(attempt); delay < ceiling/2 || delay > ceiling {
+				t.Fatalf("retryDelay(%d) = %s, want between %s and %s", attempt, delay, ceiling/2, ceiling)
+			}
+		}
+	}
+}
+
+func TestTransientErrorsAreRetried(t *testing.T) {
+	pair := newLocalTestPair(t)
+	pair.backend = &flakyBackend{StorageBackend: pair.backend, fails: 2, err: syscall.ECONNRESET}
+	pair.writeLocal("a.txt", "hello")
+
+	client := pair.client()
+	pair.reconcile(client)
+	if cloud, _ := pair.readCloud("a.txt"); cloud != "hello" {
+		t.Fatalf("cloud copy holds %q after retrying", cloud)
+	}
+	if client.hasFailed("a.txt") {
+		t.Fatal("a.txt is marked as failed after it was uploaded")
+	}
+}
+
+func TestPermanentErrorsAreReported(t *testing.T) {
+	pair := newLocalTestPair(t)
+	flaky := &flakyBackend{StorageBackend: pair.backend, fails: 1, err: errors.New("AccessDenied")}
+	pair.backend = flaky
+	pair.writeLocal("a.txt", "hello")
+
+	client := pair.client()
+	infos := make(chan *s.SyncInfo, 100)
+	client.syncInfoChannel = infos
+	pair.reconcile(client)
+	var reported *s.SyncInfo
+	for len(infos) > 0 {
+		if info := <-infos; info.SyncStatus == s.Error {
+			reported = info
+		}
+	}
+	if reported == nil || reported.Filename != "a.txt" || !strings.Contains(reported.Reason, "AccessDenied") {
+		t.Fatalf("reported %+v, want the error of a.txt", reported)
+	}
+
+	// A failed key is left alone until it is retried by hand.
+	pair.reconcile(client)
+	if _, ok := pair.readCloud("a.txt"); ok {
+		t.Fatal("failed upload was started again on the next reconcile")
+	}
+	client.RetryNow()
+	pair.waitIdle(client)
+	if cloud, _ := pair.readCloud("a.txt"); cloud != "hello" {
+		t.Fatalf("cloud copy holds %q after RetryNow", cloud)
+	}
+}
+
+func TestStopCancelsRetries(t *testing.T) {
+	pair := newLocalTestPair(t)
+	pair.backend = &flakyBackend{StorageBackend: pair.backend, fails: 1, err: syscall.ECONNRESET}
+	pair.writeLocal("a.txt", "hello")
+
+	client := pair.client()
+	if err := client.ReconcileAll(); err != nil {
+		t.Fatal(err)
+	}
+	pair.eventually("the retry to be scheduled", func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return len(client.retrying) == 1
+	})
+	client.Stop()
+	time.Sleep(2 * retryBaseDelay)
+	if _, ok := pair.readCloud("a.txt"); ok {
+		t.Fatal("stopped sync client retried the upload")
+	}
+}
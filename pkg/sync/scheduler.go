@@ -0,0 +1,139 @@
+package sync
+
+import (
+	"container/heap"
+	"strings"
+	"sync"
+
+	s "github.com/planetsp/k-drive/pkg/models"
+)
+
+const defaultTransferWorkers = 4
+
+// transferJob is one queued upload, download or delete.
+type transferJob struct {
+	key      string
+	size     int64
+	from     s.FileLocation // where the file is before the transfer
+	pinned   bool
+	seq      uint64 // keeps jobs of equal priority in arrival order
+	attempts int
+	run      func(string) error
+}
+
+// jobQueue orders jobs by priority: pinned files first, then smaller files
+// first, so one huge video does not hold up hundreds of documents.
+type jobQueue []*transferJob
+
+func (q jobQueue) Len() int { return len(q) }
+
+func (q jobQueue) Less(i, j int) bool {
+	if q[i].pinned != q[j].pinned {
+		return q[i].pinned
+	}
+	if q[i].size != q[j].size {
+		return q[i].size < q[j].size
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q jobQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *jobQueue) Push(x interface{}) { *q = append(*q, x.(*transferJob)) }
+
+func (q *jobQueue) Pop() interface{} {
+	old := *q
+	job := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return job
+}
+
+// transferScheduler runs queued jobs on a fixed number of workers.
+type transferScheduler struct {
+	mu      sync.Mutex
+	ready   *sync.Cond
+	queue   jobQueue
+	seq     uint64
+	pinned  []string
+	done    func(job *transferJob, err error)
+	stopped bool
+	workers sync.WaitGroup
+}
+
+// newTransferScheduler starts workers that run queued jobs and call done with
+// every job that finished, successfully or not.
+func newTransferScheduler(workers int, pinned []string, done func(job *transferJob, err error)) *transferScheduler {
+	scheduler := &transferScheduler{pinned: pinned, done: done}
+	scheduler.ready = sync.NewCond(&scheduler.mu)
+	scheduler.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go scheduler.work()
+	}
+	return scheduler
+}
+
+// Stop drops the queued jobs and returns once the running ones finished.
+func (scheduler *transferScheduler) Stop() {
+	scheduler.mu.Lock()
+	scheduler.stopped = true
+	scheduler.queue = nil
+	scheduler.ready.Broadcast()
+	scheduler.mu.Unlock()
+	scheduler.workers.Wait()
+}
+
+func (scheduler *transferScheduler) Enqueue(key string, size int64, from s.FileLocation, run func(string) error) {
+	scheduler.Requeue(&transferJob{
+		key:    key,
+		size:   size,
+		from:   from,
+		pinned: isPinned(key, scheduler.pinned),
+		run:    run,
+	})
+}
+
+// Requeue queues job again, e.g. to retry it, behind the jobs of the same
+// priority that are already waiting.
+func (scheduler *transferScheduler) Requeue(job *transferJob) {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	if scheduler.stopped {
+		return
+	}
+	scheduler.seq++
+	job.seq = scheduler.seq
+	heap.Push(&scheduler.queue, job)
+	scheduler.ready.Signal()
+}
+
+func (scheduler *transferScheduler) work() {
+	defer scheduler.workers.Done()
+	for {
+		scheduler.mu.Lock()
+		for scheduler.queue.Len() == 0 && !scheduler.stopped {
+			scheduler.ready.Wait()
+		}
+		if scheduler.stopped {
+			scheduler.mu.Unlock()
+			return
+		}
+		job := heap.Pop(&scheduler.queue).(*transferJob)
+		scheduler.mu.Unlock()
+
+		err := job.run(job.key)
+		scheduler.done(job, err)
+	}
+}
+
+// isPinned reports whether key is one of the pinned paths or inside a pinned
+// folder.
+func isPinned(key string, pinned []string) bool {
+	for _, path := range pinned {
+		path = strings.Trim(path, "/")
+		if path != "" && (key == path || strings.HasPrefix(key, path+"/")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package sync
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	s "github.com/planetsp/k-drive/pkg/models"
+)
+
+func TestSchedulerRunsPinnedAndSmallFilesFirst(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	finished := make(chan string, 10)
+	scheduler := newTransferScheduler(1, []string{"/pinned/"}, func(job *transferJob, err error) {
+		finished <- job.key
+	})
+	defer scheduler.Stop()
+
+	// Keep the only worker busy until everything is queued.
+	release := make(chan bool)
+	scheduler.Enqueue("blocker", 0, s.Local, func(string) error {
+		<-release
+		return nil
+	})
+	record := func(key string) error {
+		mu.Lock()
+		order = append(order, key)
+		mu.Unlock()
+		return nil
+	}
+	scheduler.Enqueue("video.mp4", 1<<30, s.Local, record)
+	scheduler.Enqueue("a.txt", 10, s.Local, record)
+	scheduler.Enqueue("pinned/huge.iso", 1<<40, s.Cloud, record)
+	scheduler.Enqueue("b.txt", 10, s.Local, record)
+	scheduler.Enqueue("pinnedness.txt", 1<<20, s.Local, record)
+	close(release)
+	for i := 0; i < 6; i++ {
+		<-finished
+	}
+
+	want := []string{"pinned/huge.iso", "a.txt", "b.txt", "pinnedness.txt", "video.mp4"}
+	if !reflect.DeepEqual(order, want) {
+		t.Fatalf("ran %v, want %v", order, want)
+	}
+}
+
+func TestSchedulerBoundsWorkers(t *testing.T) {
+	const workers = 3
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	finished := make(chan bool, 20)
+	scheduler := newTransferScheduler(workers, nil, func(job *transferJob, err error) {
+		finished <- true
+	})
+	defer scheduler.Stop()
+
+	for i := 0; i < 20; i++ {
+		scheduler.Enqueue("file", int64(i), s.Local, func(string) error {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return nil
+		})
+	}
+	for i := 0; i < 20; i++ {
+		<-finished
+	}
+	if maxRunning != workers {
+		t.Fatalf("ran up to %d jobs at once, want %d", maxRunning, workers)
+	}
+}
+
+func TestSchedulerStop(t *testing.T) {
+	var mu sync.Mutex
+	ran := 0
+	scheduler := newTransferScheduler(1, nil, func(job *transferJob, err error) {})
+	started := make(chan bool)
+	release := make(chan bool)
+	scheduler.Enqueue("running", 0, s.Local, func(string) error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	for i := 0; i < 5; i++ {
+		scheduler.Enqueue("queued", 1, s.Local, func(string) error {
+			mu.Lock()
+			ran++
+			mu.Unlock()
+			return nil
+		})
+	}
+
+	stopped := make(chan bool)
+	go func() {
+		scheduler.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned while a job was still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-stopped
+
+	scheduler.Enqueue("after stop", 1, s.Local, func(string) error {
+		mu.Lock()
+		ran++
+		mu.Unlock()
+		return nil
+	})
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if ran != 0 {
+		t.Fatalf("ran %d queued jobs after Stop", ran)
+	}
+}
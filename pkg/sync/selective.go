@@ -0,0 +1,142 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	c "github.com/planetsp/k-drive/pkg/config"
+	log "github.com/planetsp/k-drive/pkg/logging"
+	"github.com/planetsp/k-drive/pkg/storage"
+)
+
+// isSelected reports whether key is synced locally. Without a selection
+// everything is, otherwise files in the root plus the selected folders and
+// everything inside them. Selected folders are usually top-level ones, but
+// a nested folder can be selected on its own as well: the folders leading
+// to it are then created locally, without the rest of their contents.
+func (client *SyncClient) isSelected(key string, isDir bool) bool {
+	client.mu.Lock()
+	folders := client.selectedFolders
+	client.mu.Unlock()
+	if folders == nil {
+		return true
+	}
+	key = strings.Trim(key, "/")
+	if !isDir && !strings.Contains(key, "/") {
+		return true
+	}
+	for _, folder := range folders {
+		if key == folder || strings.HasPrefix(key, folder+"/") || isDir && strings.HasPrefix(folder, key+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (client *SyncClient) setSelectedFolders(folders []string) {
+	var cleaned []string
+	if folders != nil {
+		cleaned = []string{}
+	}
+	for _, folder := range folders {
+		if folder = strings.Trim(folder, "/"); folder != "" {
+			cleaned = append(cleaned, folder)
+		}
+	}
+	client.mu.Lock()
+	client.selectedFolders = cleaned
+	client.mu.Unlock()
+}
+
+// evictUnselected handles a key outside the selected folders. The cloud copy
+// is left alone and a local copy that is in sync is removed, except by
+// upload-only pairs, which keep it. It returns false if the local copy has
+// changes since it was synced, those are synced as usual first and the copy
+// is removed on a later pass. Local files that were never synced, e.g. ones created in a folder that is not selected, are
+// neither uploaded nor removed, only reported.
+func (client *SyncClient) evictUnselected(key string, local *localFile, cloud *storage.ObjectInfo) bool {
+	known := client.state.Get(key)
+	if local == nil {
+		if known != nil {
+			client.state.Delete(key)
+		}
+		return true
+	}
+	if known == nil {
+		client.flagLocalChange(key, local, "not uploaded, its folder is not selected for syncing")
+		return true
+	}
+	if cloud == nil || localFileChanged(local, known) || cloudFileChanged(cloud, known) {
+		return false
+	}
+	if client.direction == c.DirectionUploadOnly {
+		// Backups never remove local files.
+		return true
+	}
+
+	localPath, err := LocalPathForKey(client.workingDirectory, key)
+	if err != nil {
+		client.rejectKey(key, err)
+		return true
+	}
+	if err := os.Remove(localPath); err != nil && !os.IsNotExist(err) {
+		log.Error(fmt.Sprintf("failed to remove local copy of unselected %q, %v", key, err))
+		return true
+	}
+	client.state.Delete(key)
+	removeEmptyParents(client.workingDirectory, localPath)
+	log.Info("removed local copy of %q, its folder is not selected", key)
+	return true
+}
+
+// SetSelectedFolders changes which folders the sync client of a sync pair
+// keeps locally. nil syncs everything, an empty list only the files in the
+// root. Local copies of folders that are no longer selected are removed.
+func SetSelectedFolders(pairID string, folders []string) {
+	client := getRunningClient(pairID)
+	if client == nil {
+		return
+	}
+	client.setSelectedFolders(folders)
+	go func() {
+		if err := client.ReconcileAll(); err != nil {
+			log.Error(fmt.Sprintf("Failed to reconcile with cloud: %v", err))
+		}
+	}()
+}
+
+// ListCloudFolders returns every folder in the cloud of a sync pair, whether
+// it is selected or not, for choosing which ones to sync.
+func ListCloudFolders(pairID string) ([]string, error) {
+	client := getRunningClient(pairID)
+	if client == nil {
+		return nil, errors.New("sync is not running")
+	}
+	objects, err := client.listing.Objects(client.ctx, client.backend)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for key := range objects {
+		if storage.CheckKey(key) != nil {
+			continue
+		}
+		if strings.HasSuffix(key, "/") {
+			// A folder marker, the folder may be empty.
+			key = strings.TrimSuffix(key, "/")
+			seen[key] = true
+		}
+		for i := strings.LastIndex(key, "/"); i > 0; i = strings.LastIndex(key[:i], "/") {
+			seen[key[:i]] = true
+		}
+	}
+	folders := make([]string, 0, len(seen))
+	for folder := range seen {
+		folders = append(folders, folder)
+	}
+	sort.Strings(folders)
+	return folders, nil
+}
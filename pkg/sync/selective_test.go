@@ -0,0 +1,121 @@
+package sync
+
+import (
+	"testing"
+	"time"
+
+	c "github.com/planetsp/k-drive/pkg/config"
+)
+
+func TestIsSelected(t *testing.T) {
+	client := &SyncClient{}
+	if !client.isSelected("any/deep/file.txt", false) {
+		t.Fatal("without a selection everything must be selected")
+	}
+
+	client.setSelectedFolders([]string{"/docs/", "photos/2026", ""})
+	tests := []struct {
+		key   string
+		isDir bool
+		want  bool
+	}{
+		{"root.txt", false, true},
+		{"docs", true, true},
+		{"docs/", true, true},
+		{"docs/a.txt", false, true},
+		{"docs/sub/a.txt", false, true},
+		{"docs-old/a.txt", false, false},
+		{"music/a.mp3", false, false},
+		{"music/", true, false},
+		// A nested selection brings the folders leading to it, but not
+		// their other contents.
+		{"photos/", true, true},
+		{"photos/2026/a.jpg", false, true},
+		{"photos/2025/a.jpg", false, false},
+		{"photos/cover.jpg", false, false},
+	}
+	for _, test := range tests {
+		if got := client.isSelected(test.key, test.isDir); got != test.want {
+			t.Errorf("isSelected(%q, %v) = %v, want %v", test.key, test.isDir, got, test.want)
+		}
+	}
+
+	client.setSelectedFolders([]string{})
+	if !client.isSelected("root.txt", false) || client.isSelected("docs/a.txt", false) {
+		t.Fatal("an empty selection must only sync the files in the root")
+	}
+}
+
+func TestSelectiveSyncEvictsUnselectedFolders(t *testing.T) {
+	pair := newLocalTestPair(t)
+	pair.writeLocal("root.txt", "root")
+	pair.writeLocal("keep/a.txt", "keep")
+	pair.writeLocal("drop/a.txt", "drop")
+	pair.writeLocal("drop/edited.txt", "before")
+	client := pair.client()
+	pair.reconcile(client)
+
+	// Edited right before its folder is deselected.
+	time.Sleep(10 * time.Millisecond)
+	pair.writeLocal("drop/edited.txt", "after")
+	client.setSelectedFolders([]string{"keep"})
+	pair.reconcile(client)
+	pair.reconcile(client)
+
+	if _, ok := pair.readLocal("drop/a.txt"); ok {
+		t.Error("drop/a.txt was not removed locally")
+	}
+	if cloud, _ := pair.readCloud("drop/a.txt"); cloud != "drop" {
+		t.Errorf("cloud copy of drop/a.txt holds %q", cloud)
+	}
+	if _, ok := pair.readLocal("drop/edited.txt"); ok {
+		t.Error("drop/edited.txt was not removed locally")
+	}
+	if cloud, _ := pair.readCloud("drop/edited.txt"); cloud != "after" {
+		t.Errorf("edit of drop/edited.txt was lost, cloud holds %q", cloud)
+	}
+	for _, key := range []string{"root.txt", "keep/a.txt"} {
+		if _, ok := pair.readLocal(key); !ok {
+			t.Errorf("selected %s was removed", key)
+		}
+	}
+
+	// Files created in an unselected folder stay local.
+	pair.writeLocal("drop/new.txt", "new")
+	pair.reconcile(client)
+	pair.reconcile(client)
+	if local, _ := pair.readLocal("drop/new.txt"); local != "new" {
+		t.Error("new file in an unselected folder was removed")
+	}
+	if _, ok := pair.readCloud("drop/new.txt"); ok {
+		t.Error("new file in an unselected folder was uploaded")
+	}
+	if _, ok := pair.readLocal("drop/a.txt"); ok {
+		t.Error("unselected drop/a.txt was downloaded again")
+	}
+
+	// Selecting the folder again brings its files back.
+	client.setSelectedFolders(nil)
+	pair.reconcile(client)
+	if local, _ := pair.readLocal("drop/a.txt"); local != "drop" {
+		t.Errorf("drop/a.txt holds %q after selecting its folder again", local)
+	}
+	if cloud, _ := pair.readCloud("drop/new.txt"); cloud != "new" {
+		t.Errorf("drop/new.txt was not uploaded after selecting its folder, cloud holds %q", cloud)
+	}
+}
+
+func TestUploadOnlyKeepsUnselectedFolders(t *testing.T) {
+	pair := newLocalTestPair(t)
+	pair.config.SyncDirection = c.DirectionUploadOnly
+	pair.writeLocal("drop/a.txt", "drop")
+	client := pair.client()
+	pair.reconcile(client)
+
+	client.setSelectedFolders([]string{"keep"})
+	pair.reconcile(client)
+	pair.reconcile(client)
+	if local, _ := pair.readLocal("drop/a.txt"); local != "drop" {
+		t.Fatalf("backed up drop/a.txt was removed locally, holds %q", local)
+	}
+}
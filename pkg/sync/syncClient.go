@@ -2,20 +2,60 @@ package sync
 
 import (
 	"context"
-	"io/ioutil"
+	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/fsnotify/fsnotify"
 	c "github.com/planetsp/k-drive/pkg/config"
+	"github.com/planetsp/k-drive/pkg/ignore"
 	log "github.com/planetsp/k-drive/pkg/logging"
 	s "github.com/planetsp/k-drive/pkg/models"
+	"github.com/planetsp/k-drive/pkg/state"
+	"github.com/planetsp/k-drive/pkg/storage"
 )
 
+// SyncClient keeps one working directory in sync with one storage backend.
+type SyncClient struct {
+	backend          storage.StorageBackend
+	listing          *cloudListing
+	pairID           string
+	state            *state.SyncState
+	journal          *state.TransferJournal
+	queue            *state.ChangeQueue
+	workingDirectory string
+	pollingFrequency time.Duration
+	conflictPolicy   string
+	direction        string
+	ignorePatterns   []string
+	chunkSize        int64
+	chunkConcurrency int
+	syncInfoChannel  chan *s.SyncInfo
+
+	ctx             context.Context // cancelled by Stop
+	cancel          context.CancelFunc
+	monitors        sync.WaitGroup
+	scheduler       *transferScheduler
+	events          *eventDebouncer
+	mu              sync.Mutex
+	online          bool
+	ignoreRules     *ignore.Matcher
+	selectedFolders []string
+	busy            map[string]bool              // keys with a transfer queued or in flight
+	retrying        map[*transferJob]*time.Timer // failed jobs waiting to be retried
+	failed          map[string]time.Time         // keys whose last transfer gave up
+	flagged         map[string]time.Time         // local-only keys reported as not uploaded
+	rejected        map[string]bool              // cloud keys reported as impossible to sync
+}
+
+// StartSyncClient brings the running sync clients in line with the
+// configuration: pairs that are new get a sync client, pairs whose settings
+// changed are restarted with the new ones and removed pairs are stopped.
+// Updates are sent to syncInfoChannel, tagged with the pair they belong to.
 func StartSyncClient(syncInfoChannel chan *s.SyncInfo) {
 	// Check if configuration is loaded
 	if !c.IsConfigLoaded() {
@@ -24,48 +64,276 @@ func StartSyncClient(syncInfoChannel chan *s.SyncInfo) {
 	}
 
 	config := c.GetConfig()
-	if config.WorkingDirectory == "" || config.BucketName == "" {
-		log.Error("Invalid configuration: missing required fields")
+	if err := c.ValidateConfig(config); err != nil {
+		log.Error(fmt.Sprintf("Invalid configuration: %v", err))
 		return
 	}
 
+	configured := map[string]bool{}
+	for _, pair := range config.Pairs() {
+		configured[pair.ID] = true
+	}
+	for id, running := range runningClientsSnapshot() {
+		if !configured[id] {
+			log.Info("Stopping sync client %s, its sync pair was removed", running.config.DisplayName())
+			stopSyncPair(id)
+		}
+	}
+
+	for _, pair := range config.Pairs() {
+		pairConfig := config.ForPair(pair)
+		passphrase, err := c.LoadPassphrase(pair.ID)
+		if err != nil {
+			log.Error(fmt.Sprintf("Failed to read passphrase of %s: %v", pair.DisplayName(), err))
+		}
+		if running := runningClientsSnapshot()[pair.ID]; running != nil {
+			if reflect.DeepEqual(running.config, pairConfig) && running.passphrase == passphrase {
+				continue
+			}
+			log.Info("Restarting sync client %s with its new settings", pair.DisplayName())
+			stopSyncPair(pair.ID)
+		}
+		startSyncPair(pairConfig, passphrase, syncInfoChannel)
+	}
+}
+
+// stopSyncPair stops the sync client of a sync pair, if it is running.
+func stopSyncPair(pairID string) {
+	runningMu.Lock()
+	running := runningClients[pairID]
+	delete(runningClients, pairID)
+	runningMu.Unlock()
+	if running != nil {
+		running.client.Stop()
+	}
+}
+
+// startSyncPair starts the sync client of the single sync pair in config,
+// which was configured with passphrase.
+func startSyncPair(config *c.Configuration, passphrase string, syncInfoChannel chan *s.SyncInfo) {
 	// Check if working directory exists
 	if _, err := os.Stat(config.WorkingDirectory); os.IsNotExist(err) {
-		log.Error("Working directory does not exist: %s", config.WorkingDirectory)
+		log.Error(fmt.Sprintf("Working directory does not exist: %s", config.WorkingDirectory))
 		log.Info("Please update your configuration with a valid directory path")
 		return
 	}
 
-	client := CreateS3Client()
-	if client == nil {
-		log.Error("Failed to create S3 client, cannot start sync")
+	backend, err := storage.NewStorageBackend(config)
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to create storage backend, cannot start sync: %v", err))
 		return
 	}
 
-	log.Info("Starting sync client with working directory: %s", config.WorkingDirectory)
-	log.Info("Syncing with S3 bucket: %s", config.BucketName)
+	syncState, err := state.LoadSyncState(c.GetStateFilePath(config.ID), config.WorkingDirectory, backend.Name())
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to load sync state, cannot start sync: %v", err))
+		return
+	}
 
-	done := make(chan bool)
-	go MonitorLocalFolderForChanges(client, syncInfoChannel)
-	go MonitorCloudForChanges(client, syncInfoChannel)
+	journal, err := state.LoadTransferJournal(c.GetJournalFilePath(config.ID), config.WorkingDirectory, backend.Name())
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to load transfer journal, cannot start sync: %v", err))
+		return
+	}
+
+	queue, err := state.LoadChangeQueue(c.GetQueueFilePath(config.ID), config.WorkingDirectory, backend.Name())
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to load change queue, cannot start sync: %v", err))
+		return
+	}
 
-	<-done
+	log.Info("Starting sync client %s with working directory: %s", config.DisplayName(), config.WorkingDirectory)
+	log.Info("Syncing with %s", backend.Name())
+
+	client := NewSyncClient(config, backend, syncState, journal, queue, syncInfoChannel)
+	runningMu.Lock()
+	runningClients[config.ID] = &runningClient{client: client, config: config, passphrase: passphrase}
+	runningMu.Unlock()
+	client.Start()
 }
 
-func CreateS3Client() *s3.Client {
-	// Load the Shared AWS Configuration (~/.aws/config)
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		log.Error("Failed to load AWS configuration: %v", err)
-		log.Info("Ensure you have AWS credentials configured in ~/.aws/credentials or environment variables")
-		return nil
+// runningClient is a started sync client and the settings it was started
+// with, to tell when it has to be restarted.
+type runningClient struct {
+	client     *SyncClient
+	config     *c.Configuration
+	passphrase string
+}
+
+var (
+	runningMu      sync.Mutex
+	runningClients = map[string]*runningClient{} // by sync pair ID
+)
+
+func runningClientsSnapshot() map[string]*runningClient {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	snapshot := make(map[string]*runningClient, len(runningClients))
+	for id, running := range runningClients {
+		snapshot[id] = running
+	}
+	return snapshot
+}
+
+func getRunningClient(pairID string) *SyncClient {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	if running := runningClients[pairID]; running != nil {
+		return running.client
+	}
+	return nil
+}
+
+// RetryFailedTransfers makes the running sync clients try all failed and
+// waiting transfers again right away.
+func RetryFailedTransfers() {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	for _, running := range runningClients {
+		go running.client.RetryNow()
+	}
+}
+
+func NewSyncClient(config *c.Configuration, backend storage.StorageBackend, syncState *state.SyncState, journal *state.TransferJournal, queue *state.ChangeQueue, syncInfoChannel chan *s.SyncInfo) *SyncClient {
+	// Downloads are split into chunks the same way uploads are split into
+	// multipart parts.
+	chunkSize := config.MultipartPartSizeMB * 1024 * 1024
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	chunkConcurrency := config.MultipartConcurrency
+	if chunkConcurrency <= 0 {
+		chunkConcurrency = defaultChunkConcurrency
+	}
+	workers := config.TransferWorkers
+	if workers <= 0 {
+		workers = defaultTransferWorkers
+	}
+	ignorePatterns := config.IgnorePatterns
+	if ignorePatterns == nil {
+		// Configurations from before ignore patterns existed.
+		ignorePatterns = c.DefaultIgnorePatterns
 	}
 
-	// Create an Amazon S3 service client
-	client := s3.NewFromConfig(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &SyncClient{
+		ctx:              ctx,
+		cancel:           cancel,
+		backend:          backend,
+		listing:          newCloudListing(config.CloudListingInterval * time.Second),
+		state:            syncState,
+		pairID:           config.ID,
+		journal:          journal,
+		queue:            queue,
+		workingDirectory: config.WorkingDirectory,
+		pollingFrequency: config.LocalDirectoryPollingFrequency * time.Second,
+		conflictPolicy:   config.ConflictPolicy,
+		direction:        config.SyncDirection,
+		ignorePatterns:   ignorePatterns,
+		chunkSize:        chunkSize,
+		chunkConcurrency: chunkConcurrency,
+		syncInfoChannel:  syncInfoChannel,
+		busy:             map[string]bool{},
+		retrying:         map[*transferJob]*time.Timer{},
+		failed:           map[string]time.Time{},
+		flagged:          map[string]time.Time{},
+		rejected:         map[string]bool{},
+	}
+	client.scheduler = newTransferScheduler(workers, config.PinnedPaths, client.transferDone)
+	client.events = newEventDebouncer(eventQuietPeriod, client.handleLocalChange)
+	client.loadIgnoreRules()
+	client.setSelectedFolders(config.SelectedFolders)
 	return client
 }
-func DownloadFileFromCloud(client *s3.Client, filename string, syncInfoChannel chan *s.SyncInfo) {
+
+// report sends info to the UI, tagged with the pair it belongs to.
+func (client *SyncClient) report(info *s.SyncInfo) {
+	info.Pair = client.pairID
+	client.syncInfoChannel <- info
+}
+
+// Start watches the working directory and the cloud until Stop is called.
+func (client *SyncClient) Start() {
+	client.monitors.Add(2)
+	go func() {
+		defer client.monitors.Done()
+		client.MonitorLocalFolderForChanges()
+	}()
+	go func() {
+		defer client.monitors.Done()
+		client.MonitorCloudForChanges()
+	}()
+}
+
+// Stop cancels the transfers in flight and returns once the sync client
+// stopped touching the working directory and the cloud. Interrupted
+// transfers stay in the journal and continue when the pair starts again.
+func (client *SyncClient) Stop() {
+	client.cancel()
+	client.events.Stop()
+	client.mu.Lock()
+	for job, timer := range client.retrying {
+		timer.Stop()
+		delete(client.retrying, job)
+	}
+	client.mu.Unlock()
+	client.scheduler.Stop()
+	client.monitors.Wait()
+	if err := client.state.Save(); err != nil {
+		log.Error(fmt.Sprintf("Failed to save sync state: %v", err))
+	}
+}
+
+// runTransfer queues transfer for key unless another transfer for the same
+// key is already queued or running. size orders the queue and from is where
+// the file currently is, for the Queued status.
+func (client *SyncClient) runTransfer(key string, size int64, from s.FileLocation, transfer func(string) error) {
+	client.mu.Lock()
+	if client.busy[key] {
+		client.mu.Unlock()
+		return
+	}
+	client.busy[key] = true
+	client.mu.Unlock()
+
+	client.report(&s.SyncInfo{
+		Filename:     key,
+		DateModified: time.Now(),
+		Location:     from,
+		SyncStatus:   s.Queued,
+	})
+	client.scheduler.Enqueue(key, size, from, transfer)
+}
+
+func (client *SyncClient) isBusy(key string) bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.busy[key]
+}
+
+// DownloadFileFromCloud replaces the local copy of filename with the cloud
+// copy, unless the local copy changed since the last sync.
+func (client *SyncClient) DownloadFileFromCloud(filename string) error {
+	return client.download(filename, nil)
+}
+
+// downloadReplacing returns a transfer that downloads a key over local, the
+// local copy the download was decided on.
+func (client *SyncClient) downloadReplacing(local *localFile) func(string) error {
+	return func(filename string) error {
+		return client.download(filename, local)
+	}
+}
+
+// download fetches filename. A local copy is only replaced if it is still
+// expected or in sync with the state, a local copy that was edited
+// meanwhile is kept as a conflict copy.
+func (client *SyncClient) download(filename string, expected *localFile) error {
+	localPath, err := LocalPathForKey(client.workingDirectory, filename)
+	if err != nil {
+		return err
+	}
+
 	// Send initial downloading status
 	downloadingInfo := &s.SyncInfo{
 		Filename:     filename,
@@ -73,57 +341,64 @@ func DownloadFileFromCloud(client *s3.Client, filename string, syncInfoChannel c
 		Location:     s.Cloud,
 		SyncStatus:   s.Downloading,
 	}
-	syncInfoChannel <- downloadingInfo
+	client.report(downloadingInfo)
 
-	result, err := client.GetObject(context.TODO(),
-		&s3.GetObjectInput{
-			Bucket: aws.String(c.GetConfig().BucketName),
-			Key:    aws.String(filename),
-		})
+	err = os.MkdirAll(filepath.Dir(localPath), 0755)
 	if err != nil {
-		log.Error(err)
-		return
+		return err
 	}
 
-	defer result.Body.Close()
-	body, err := ioutil.ReadAll(result.Body)
+	log.Info("downloading %q from cloud", filename)
+	info, hash, err := client.downloadToFile(client.ctx, filename, localPath, expected)
 	if err != nil {
-		log.Error(err)
-		return
+		return fmt.Errorf("failed to download %q: %w", filename, err)
 	}
-	workingDirectory := c.GetConfig().WorkingDirectory
-	err = ioutil.WriteFile(workingDirectory+filename, body, 0644)
-	if err != nil {
-		log.Error(err)
-		return
+
+	local, err := statLocalFile(localPath)
+	if err != nil || local == nil {
+		return fmt.Errorf("failed to stat downloaded file %q: %v", filename, err)
 	}
-	log.Info("downloading %q from cloud", filename)
+	client.state.Put(state.FileState{
+		Path:     filename,
+		Size:     local.Size,
+		ModTime:  local.ModTime,
+		ETag:     info.ETag,
+		Hash:     hash,
+		SyncedAt: time.Now(),
+	})
 
 	// Send completion status
 	syncedInfo := &s.SyncInfo{
 		Filename:     filename,
-		DateModified: *result.LastModified,
+		DateModified: info.LastModified,
 		Location:     s.Local,
 		SyncStatus:   s.Synced,
 	}
-	syncInfoChannel <- syncedInfo
+	client.report(syncedInfo)
+	return nil
 }
 
-func UploadFileToCloud(client *s3.Client, filename string, syncInfoChannel chan *s.SyncInfo) {
-	workingDirectory := c.GetConfig().WorkingDirectory
+func (client *SyncClient) UploadFileToCloud(filename string) error {
+	localPath, err := LocalPathForKey(client.workingDirectory, filename)
+	if err != nil {
+		return err
+	}
 
-	f, err := os.Open(workingDirectory + filename)
+	f, err := os.Open(localPath)
 	if err != nil {
-		log.Error("failed to open file %q, %v", filename, err)
-		return
+		return fmt.Errorf("failed to open file %q: %w", filename, err)
 	}
 	defer f.Close()
 
 	// get last modified time
-	file, err := os.Stat(workingDirectory + filename)
+	file, err := f.Stat()
 	if err != nil {
-		log.Error(err)
-		return
+		return err
+	}
+
+	hash, err := hashFile(localPath)
+	if err != nil {
+		return err
 	}
 
 	// Send initial uploading status
@@ -133,21 +408,27 @@ func UploadFileToCloud(client *s3.Client, filename string, syncInfoChannel chan
 		Location:     s.Local,
 		SyncStatus:   s.Uploading,
 	}
-	syncInfoChannel <- uploadingInfo
+	client.report(uploadingInfo)
 
 	log.Info("uploading %q to cloud", filename)
-	_, err = client.PutObject(context.TODO(),
-		&s3.PutObjectInput{
-			Bucket: aws.String(c.GetConfig().BucketName),
-			Key:    aws.String(filename),
-			Body:   f,
-		})
-
+	info, err := client.putFile(client.ctx, filename, f, file, hash)
 	if err != nil {
-		log.Error("failed to upload file %q, %v", filename, err)
-		return
+		return fmt.Errorf("failed to upload file %q: %w", filename, err)
 	}
 
+	client.listing.Put(*info)
+
+	// Record what was read before the upload started, if the file changed
+	// meanwhile the next reconcile notices and uploads it again.
+	client.state.Put(state.FileState{
+		Path:     filename,
+		Size:     file.Size(),
+		ModTime:  file.ModTime(),
+		ETag:     info.ETag,
+		Hash:     hash,
+		SyncedAt: time.Now(),
+	})
+
 	// Send completion status
 	syncedInfo := &s.SyncInfo{
 		Filename:     filename,
@@ -155,161 +436,280 @@ func UploadFileToCloud(client *s3.Client, filename string, syncInfoChannel chan
 		Location:     s.Cloud,
 		SyncStatus:   s.Synced,
 	}
-	syncInfoChannel <- syncedInfo
+	client.report(syncedInfo)
+	return nil
 }
-func GetSyncDiff(client *s3.Client, workingDirectory string) *s.SyncDiff {
-	diff := s.SyncDiff{
-		FilesNotAvailableInCloud: ListItemsInCloudNotAvailableLocally(client),
-		FilesNotAvailableLocally: ListItemsInLocalDirNotAvailableInCloud(client),
+
+// DeleteFileFromCloud removes filename from the backend after it was deleted
+// locally.
+func (client *SyncClient) DeleteFileFromCloud(filename string) error {
+	// Editors often save by renaming the old file away and writing a new one
+	// under the same name, which must not end up deleting the cloud copy.
+	localPath, err := LocalPathForKey(client.workingDirectory, filename)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(localPath); err == nil {
+		return nil
+	}
+
+	err = client.backend.Delete(client.ctx, filename)
+	if err != nil && err != storage.ErrNotFound {
+		return fmt.Errorf("failed to delete %q from cloud: %w", filename, err)
 	}
-	return &diff
+	client.listing.Remove(filename)
+	client.state.Delete(filename)
+	log.Info("deleted %q from cloud", filename)
+	client.report(&s.SyncInfo{
+		Filename:     filename,
+		DateModified: time.Now(),
+		Location:     s.Cloud,
+		SyncStatus:   s.Deleted,
+	})
+	return nil
+}
+
+// DeleteLocalFile removes the local copy of filename after it disappeared
+// from the cloud, along with any folders that are left empty. A local copy
+// that was edited since the last sync, e.g. while the delete was queued, is
+// uploaded instead.
+func (client *SyncClient) DeleteLocalFile(filename string) error {
+	localPath, err := LocalPathForKey(client.workingDirectory, filename)
+	if err != nil {
+		return err
+	}
+	local, err := statLocalFile(localPath)
+	if err != nil {
+		return err
+	}
+	if local != nil && !local.IsDir {
+		changed, err := client.localChangedSinceSync(filename, localPath, local)
+		if err != nil {
+			return err
+		}
+		if changed {
+			log.Info("%q changed locally after it was deleted in the cloud, keeping it", filename)
+			if directAction(client.direction, actionUpload, local, nil) != actionUpload {
+				client.flagLocalChange(filename, local, downloadOnlyReason)
+				return nil
+			}
+			return client.UploadFileToCloud(filename)
+		}
+	}
+	err = os.Remove(localPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete local copy of %q: %w", filename, err)
+	}
+	client.state.Delete(filename)
+	removeEmptyParents(client.workingDirectory, localPath)
+	log.Info("deleted %q locally", filename)
+	client.report(&s.SyncInfo{
+		Filename:     filename,
+		DateModified: time.Now(),
+		Location:     s.Local,
+		SyncStatus:   s.Deleted,
+	})
+	return nil
 }
-func ListItemsInCloud(client *s3.Client) map[string]bool {
+
+func ListItemsInCloud(backend storage.StorageBackend) map[string]bool {
 	filenameSet := make(map[string]bool)
 	log.Debug("Checking cloud")
 
-	output, err := client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-		Bucket: aws.String(c.GetConfig().BucketName),
-	})
+	objects, err := backend.List(context.TODO(), "")
 	if err != nil {
-		log.Error("Failed to list objects in cloud: %v", err)
-		log.Info("Please ensure your AWS credentials and region are properly configured")
+		log.Error(fmt.Sprintf("Failed to list objects in cloud: %v", err))
+		log.Info("Please ensure your cloud credentials and configuration are correct")
 		return filenameSet // Return empty set on error
 	}
-	for _, object := range output.Contents {
-		filenameSet[*object.Key] = true
+	for _, object := range objects {
+		filenameSet[object.Key] = true
 	}
 	return filenameSet
 }
+
+// ListItemsInLocalDir returns the keys of everything below workingDirectory.
+// Directories are included with a trailing '/' so folder markers in the
+// cloud are recognised as already present.
 func ListItemsInLocalDir(workingDirectory string) map[string]bool {
 	filenameSet := make(map[string]bool)
-	files, err := ioutil.ReadDir(workingDirectory)
+	files, err := scanLocalDir(workingDirectory, nil)
 	if err != nil {
 		log.Error(err)
 	}
-
-	for _, file := range files {
-		if !file.IsDir() {
-			filenameSet[file.Name()] = true
-
-		}
+	for key := range files {
+		filenameSet[key] = true
 	}
 	return filenameSet
 }
-func ListItemsInCloudNotAvailableLocally(client *s3.Client) []s.SyncInfo {
-	workingDirectory := c.GetConfig().WorkingDirectory
 
-	cloudFilenames := ListItemsInCloud(client)
-	localFilenames := ListItemsInLocalDir(workingDirectory)
-	return ListDiffBetweenSets(cloudFilenames, localFilenames)
-}
-
-func ListItemsInLocalDirNotAvailableInCloud(client *s3.Client) []s.SyncInfo {
-	workingDirectory := c.GetConfig().WorkingDirectory
+// staleUploadAge is how old an unfinished multipart upload must be before it
+// is considered abandoned. Younger ones may belong to another machine syncing
+// the same bucket.
+const staleUploadAge = 24 * time.Hour
 
-	cloudFilenames := ListItemsInCloud(client)
-	localFilenames := ListItemsInLocalDir(workingDirectory)
-	return ListDiffBetweenSets(localFilenames, cloudFilenames)
-}
-
-func ListDiffBetweenSets(mapA map[string]bool, sliceB map[string]bool) []s.SyncInfo {
-	diff := []s.SyncInfo{}
-	for k := range mapA {
-		exists := sliceB[k]
-		if !exists {
-			diff = append(diff, s.SyncInfo{Filename: k, DateModified: time.Now()})
-		}
-	}
-	return diff
-}
-func MonitorCloudForChanges(client *s3.Client, syncInfoChannel chan *s.SyncInfo) {
-	// Test AWS connectivity first
-	testInput := &s3.ListObjectsV2Input{
-		Bucket: aws.String(c.GetConfig().BucketName),
+func (client *SyncClient) MonitorCloudForChanges() {
+	// Nothing can be synced before the backend answers, local changes are
+	// queued meanwhile.
+	if !client.waitUntilOnline() {
+		return
 	}
+	log.Info("%s connectivity test successful", client.backend.Name())
 
-	_, err := client.ListObjectsV2(context.TODO(), testInput)
-	if err != nil {
-		bucketName := c.GetConfig().BucketName
-		log.Error("Failed to connect to AWS S3: %v", err)
-
-		// Provide specific guidance based on error type
-		errorStr := err.Error()
-		if strings.Contains(errorStr, "PermanentRedirect") || strings.Contains(errorStr, "301") {
-			log.Info("Bucket '%s' exists in a different AWS region than configured", bucketName)
-			log.Info("Solutions:")
-			log.Info("1. Update your ~/.aws/config to use the correct region for bucket '%s'", bucketName)
-			log.Info("2. Or create a new bucket in your current region")
-			log.Info("3. Or update the bucket name in conf.json to a bucket in your current region")
-		} else if strings.Contains(errorStr, "region") {
-			log.Info("AWS region configuration issue. Please set your region in ~/.aws/config or AWS_DEFAULT_REGION environment variable")
-		} else if strings.Contains(errorStr, "credentials") || strings.Contains(errorStr, "InvalidAccessKeyId") {
-			log.Info("AWS credentials issue. Please check your ~/.aws/credentials or environment variables")
-		} else if strings.Contains(errorStr, "NoSuchBucket") {
-			log.Info("Bucket '%s' does not exist. Please create it or update conf.json with a valid bucket name", bucketName)
-		} else {
-			log.Info("Please check your AWS configuration. See AWS_SETUP.md for help")
+	resumed := client.resumeTransfers()
+	if cleaner, ok := client.backend.(storage.UploadCleaner); ok {
+		if err := cleaner.AbortStaleUploads(client.ctx, staleUploadAge, resumed); err != nil {
+			log.Error(fmt.Sprintf("Failed to clean up abandoned uploads: %v", err))
 		}
+	}
 
-		log.Info("Cloud monitoring disabled until AWS configuration is fixed")
-		return // Exit if we can't connect to AWS
+	// Catch up with everything that happened while k-drive was not running
+	client.replayQueue()
+	if err := client.ReconcileAll(); err != nil {
+		log.Error(fmt.Sprintf("Failed to reconcile with cloud: %v", err))
 	}
 
-	log.Info("AWS S3 connectivity test successful")
-	uptimeTicker := time.NewTicker(c.GetConfig().LocalDirectoryPollingFrequency * time.Second)
+	uptimeTicker := time.NewTicker(client.pollingFrequency)
 	defer uptimeTicker.Stop()
 
 	for {
 		select {
+		case <-client.ctx.Done():
+			return
 		case <-uptimeTicker.C:
-			filesToBeSyncedToLocalDir := ListItemsInCloudNotAvailableLocally(client)
-			for _, syncInfo := range filesToBeSyncedToLocalDir {
-				go DownloadFileFromCloud(client, syncInfo.Filename, syncInfoChannel)
+			if err := client.ReconcileAll(); err != nil {
+				if client.ctx.Err() != nil {
+					return
+				}
+				log.Error(fmt.Sprintf("Failed to reconcile with cloud: %v", err))
+				if client.backend.CheckConnection(client.ctx) != nil {
+					client.setOnline(false)
+					if !client.waitUntilOnline() {
+						return
+					}
+					client.replayQueue()
+				}
 			}
 		}
 	}
 }
 
-func MonitorLocalFolderForChanges(client *s3.Client, syncInfoChannel chan *s.SyncInfo) {
+func (client *SyncClient) MonitorLocalFolderForChanges() {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		log.Error(err)
+		return
 	}
-	workingDirectory := c.GetConfig().WorkingDirectory
 
-	err = watcher.Add(workingDirectory)
+	err = AddWatchesRecursively(watcher, client.workingDirectory, client.isIgnoredPath)
 	if err != nil {
 		log.Error(err)
 	}
 	defer watcher.Close()
 	for {
 		select {
+		case <-client.ctx.Done():
+			return
 		case event, ok := <-watcher.Events:
 			if !ok {
 				return
 			}
-			log.Info("event:", event)
-			filename := GetEventFilename(event.Name)
-			if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
-				// Check if file is available in cloud at the time of the event
-				availableInCloud := ListItemsInCloud(client)
-				if _, ok := availableInCloud[filename]; !ok {
-					go UploadFileToCloud(client, filename, syncInfoChannel)
-					log.Info("modified file:", filename)
+			log.Info("event: %v", event)
+			filename := GetEventFilename(client.workingDirectory, event.Name)
+			if filename == "" {
+				continue
+			}
+			if filename == ignore.Filename {
+				client.loadIgnoreRules()
+			}
+			info, err := os.Stat(event.Name)
+			isDir := err == nil && info.IsDir()
+			if client.isIgnored(filename, isDir) {
+				continue
+			}
+			if event.Op&fsnotify.Create == fsnotify.Create && isDir {
+				// Watch the new folder, anything written to it before the
+				// watch was in place is picked up by reconcilePath.
+				if err := AddWatchesRecursively(watcher, event.Name, client.isIgnoredPath); err != nil {
+					log.Error(err)
 				}
 			}
+			// Creates, writes, removals and renames all end up in the same
+			// place: once the path settled, compare it with the cloud and
+			// the sync state. A rename shows up as a removal of the old name
+			// followed by a create of the new one.
+			client.events.Touch(filename, event.Name)
 		case err, ok := <-watcher.Errors:
 			if !ok {
 				return
 			}
-			log.Error("error:", err)
+			log.Error(fmt.Sprintf("error: %v", err))
 		}
 	}
 
 }
 
-func GetEventFilename(eventName string) string {
-	splitPathNameSlice := strings.Split(eventName, "/")
-	filename := splitPathNameSlice[len(splitPathNameSlice)-1]
-	return filename
+// GetEventFilename maps the path of a filesystem event to its key, or "" if
+// the path is outside workingDirectory.
+func GetEventFilename(workingDirectory string, eventName string) string {
+	if isDownloadTempFile(eventName) {
+		return ""
+	}
+	key, err := KeyForLocalPath(workingDirectory, eventName)
+	if err != nil {
+		return ""
+	}
+	return key
+}
+
+// KeyForLocalPath converts a path below workingDirectory into a '/' separated
+// key relative to it.
+func KeyForLocalPath(workingDirectory string, path string) (string, error) {
+	rel, err := filepath.Rel(workingDirectory, path)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." {
+		return "", nil
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of %s", path, workingDirectory)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// LocalPathForKey converts a key into the path of its local copy below
+// workingDirectory. Keys that would end up anywhere else, e.g. ones
+// containing "..", are rejected.
+func LocalPathForKey(workingDirectory string, key string) (string, error) {
+	if err := storage.CheckKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(workingDirectory, filepath.FromSlash(key)), nil
+}
+
+// AddWatchesRecursively watches root and every folder below it, except the
+// ones skip returns true for.
+func AddWatchesRecursively(watcher *fsnotify.Watcher, root string, skip func(path string, isDir bool) bool) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if skip != nil && skip(path, true) {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
+
+func removeEmptyParents(workingDirectory string, path string) {
+	root := filepath.Clean(workingDirectory)
+	for dir := filepath.Dir(path); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
 }
@@ -0,0 +1,337 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	c "github.com/planetsp/k-drive/pkg/config"
+	"github.com/planetsp/k-drive/pkg/fakes3"
+	s "github.com/planetsp/k-drive/pkg/models"
+	"github.com/planetsp/k-drive/pkg/state"
+	"github.com/planetsp/k-drive/pkg/storage"
+)
+
+// testPair is a working directory synced with a backend, with the state
+// files of the sync client kept in a separate folder.
+type testPair struct {
+	t       *testing.T
+	work    string
+	stateIn string
+	backend storage.StorageBackend
+	config  *c.Configuration
+	infos   chan *s.SyncInfo
+}
+
+func newTestPair(t *testing.T, backend storage.StorageBackend) *testPair {
+	t.Helper()
+	pair := &testPair{
+		t:       t,
+		work:    t.TempDir(),
+		stateIn: t.TempDir(),
+		backend: backend,
+		infos:   make(chan *s.SyncInfo, 100),
+	}
+	pair.config = &c.Configuration{SyncPair: c.NewSyncPair()}
+	pair.config.WorkingDirectory = pair.work
+	pair.config.LocalDirectoryPollingFrequency = 1
+	pair.config.IgnorePatterns = c.DefaultIgnorePatterns
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-pair.infos:
+			case <-done:
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() { close(done) })
+	return pair
+}
+
+func newLocalTestPair(t *testing.T) *testPair {
+	t.Helper()
+	backend, err := storage.NewLocalBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestPair(t, backend)
+}
+
+// client returns a sync client for the pair, loading the state the previous
+// one saved.
+func (pair *testPair) client() *SyncClient {
+	pair.t.Helper()
+	path := filepath.Join(pair.stateIn, "state.json")
+	syncState, err := state.LoadSyncState(path, pair.work, pair.backend.Name())
+	if err != nil {
+		pair.t.Fatal(err)
+	}
+	journal, err := state.LoadTransferJournal(path+".journal", pair.work, pair.backend.Name())
+	if err != nil {
+		pair.t.Fatal(err)
+	}
+	queue, err := state.LoadChangeQueue(path+".queue", pair.work, pair.backend.Name())
+	if err != nil {
+		pair.t.Fatal(err)
+	}
+	client := NewSyncClient(pair.config, pair.backend, syncState, journal, queue, pair.infos)
+	client.online = true
+	return client
+}
+
+// reconcile runs a single pass of client and waits for its transfers.
+func (pair *testPair) reconcile(client *SyncClient) {
+	pair.t.Helper()
+	if err := client.ReconcileAll(); err != nil {
+		pair.t.Fatal(err)
+	}
+	pair.waitIdle(client)
+}
+
+func (pair *testPair) waitIdle(client *SyncClient) {
+	pair.t.Helper()
+	pair.eventually("transfers to finish", func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return len(client.busy) == 0
+	})
+}
+
+func (pair *testPair) eventually(what string, condition func() bool) {
+	pair.t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+		if condition() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	pair.t.Fatalf("timed out waiting for %s", what)
+}
+
+// blockWorker keeps the only transfer worker of client, which needs
+// TransferWorkers set to 1, busy until the returned function is called, so
+// transfers stay queued meanwhile.
+func (pair *testPair) blockWorker(client *SyncClient) func() {
+	started := make(chan bool)
+	release := make(chan bool)
+	client.scheduler.Enqueue("blocker", 0, s.Local, func(string) error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	return func() { close(release) }
+}
+
+func (pair *testPair) local(key string) string {
+	return filepath.Join(pair.work, filepath.FromSlash(key))
+}
+
+func (pair *testPair) writeLocal(key string, data string) {
+	pair.t.Helper()
+	path := pair.local(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		pair.t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		pair.t.Fatal(err)
+	}
+}
+
+func (pair *testPair) readLocal(key string) (string, bool) {
+	data, err := ioutil.ReadFile(pair.local(key))
+	return string(data), err == nil
+}
+
+func (pair *testPair) putCloud(key string, data string) {
+	pair.t.Helper()
+	_, err := pair.backend.Put(context.Background(), key, strings.NewReader(data), int64(len(data)), nil)
+	if err != nil {
+		pair.t.Fatal(err)
+	}
+}
+
+func (pair *testPair) readCloud(key string) (string, bool) {
+	body, _, err := pair.backend.Get(context.Background(), key)
+	if err != nil {
+		return "", false
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
+	return string(data), err == nil
+}
+
+func TestSyncClientRunsAgainstLocalBackend(t *testing.T) {
+	pair := newLocalTestPair(t)
+	pair.writeLocal("before/start.txt", "local before start")
+	pair.putCloud("cloud.txt", "cloud before start")
+
+	client := pair.client()
+	client.pollingFrequency = 100 * time.Millisecond
+	client.Start()
+	pair.eventually("the initial sync", func() bool {
+		cloud, _ := pair.readCloud("before/start.txt")
+		local, _ := pair.readLocal("cloud.txt")
+		return cloud == "local before start" && local == "cloud before start"
+	})
+
+	// Local changes arrive through the watcher, cloud changes through the
+	// periodic reconcile.
+	pair.writeLocal("while/running.txt", "written while running")
+	pair.putCloud("cloud.txt", "changed in the cloud")
+	pair.eventually("changes while running", func() bool {
+		cloud, _ := pair.readCloud("while/running.txt")
+		local, _ := pair.readLocal("cloud.txt")
+		return cloud == "written while running" && local == "changed in the cloud"
+	})
+	if err := os.Remove(pair.local("before/start.txt")); err != nil {
+		t.Fatal(err)
+	}
+	pair.eventually("the deletion", func() bool {
+		_, ok := pair.readCloud("before/start.txt")
+		return !ok
+	})
+
+	stopped := make(chan bool)
+	go func() {
+		client.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Stop did not return")
+	}
+
+	// A stopped client leaves the working directory alone.
+	pair.putCloud("after/stop.txt", "after stop")
+	time.Sleep(300 * time.Millisecond)
+	if _, ok := pair.readLocal("after/stop.txt"); ok {
+		t.Fatal("stopped sync client downloaded a file")
+	}
+
+	// The next client continues from the saved state.
+	client = pair.client()
+	pair.reconcile(client)
+	if local, _ := pair.readLocal("after/stop.txt"); local != "after stop" {
+		t.Fatalf("after/stop.txt holds %q after restarting", local)
+	}
+	if _, ok := pair.readLocal("before/start.txt"); ok {
+		t.Fatal("deleted file came back after restarting")
+	}
+}
+
+func TestSyncClientMonitorsFakeS3(t *testing.T) {
+	server := fakes3.NewServer("k-drive")
+	t.Cleanup(server.Close)
+	backend := storage.NewS3Backend(storage.CreateS3Client(server.S3Options), "k-drive")
+	backend.SetPrefix("laptop")
+	pair := newTestPair(t, backend)
+
+	pair.writeLocal("notes.txt", "local notes")
+	client := pair.client()
+	client.pollingFrequency = 100 * time.Millisecond
+	client.Start()
+	defer client.Stop()
+
+	pair.eventually("the upload", func() bool {
+		data, ok := server.GetObject("k-drive", "laptop/notes.txt")
+		return ok && string(data) == "local notes"
+	})
+	server.PutObject("k-drive", "laptop/docs/remote.txt", []byte("from another machine"), nil)
+	pair.eventually("the download", func() bool {
+		local, _ := pair.readLocal("docs/remote.txt")
+		return local == "from another machine"
+	})
+	server.PutObject("k-drive", "elsewhere/other.txt", []byte("other pair"), nil)
+	time.Sleep(300 * time.Millisecond)
+	if _, err := os.Stat(pair.local("elsewhere")); !os.IsNotExist(err) {
+		t.Fatal("object outside of the prefix was synced")
+	}
+}
+
+func TestSyncClientSkipsKeysOutsideWorkingDirectory(t *testing.T) {
+	server := fakes3.NewServer("k-drive")
+	t.Cleanup(server.Close)
+	backend := storage.NewS3Backend(storage.CreateS3Client(server.S3Options), "k-drive")
+	backend.SetPrefix("laptop/pair")
+	pair := newTestPair(t, backend)
+	outside := filepath.Dir(pair.work)
+
+	for _, key := range []string{"../../escaped.txt", "docs/../../../escaped.txt", "../escaped/"} {
+		server.PutObject("k-drive", "laptop/pair/"+key, []byte("escaped"), nil)
+	}
+	server.PutObject("k-drive", "laptop/pair/fine.txt", []byte("fine"), nil)
+
+	client := pair.client()
+	pair.reconcile(client)
+	if local, _ := pair.readLocal("fine.txt"); local != "fine" {
+		t.Fatalf("fine.txt holds %q", local)
+	}
+	for _, path := range []string{filepath.Join(outside, "escaped.txt"), filepath.Join(outside, "escaped"), filepath.Join(filepath.Dir(outside), "escaped.txt")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("%s was created outside of the working directory", path)
+		}
+	}
+	client.mu.Lock()
+	rejected := len(client.rejected)
+	client.mu.Unlock()
+	if rejected != 3 {
+		t.Fatalf("reported %d keys, want 3", rejected)
+	}
+
+	victim := filepath.Join(outside, "victim.txt")
+	if err := ioutil.WriteFile(victim, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.DownloadFileFromCloud("../victim.txt"); !errors.Is(err, storage.ErrInvalidKey) {
+		t.Fatalf("DownloadFileFromCloud = %v, want ErrInvalidKey", err)
+	}
+	if err := client.DeleteLocalFile("../victim.txt"); !errors.Is(err, storage.ErrInvalidKey) {
+		t.Fatalf("DeleteLocalFile = %v, want ErrInvalidKey", err)
+	}
+	if data, _ := ioutil.ReadFile(victim); string(data) != "keep" {
+		t.Fatal("file outside of the working directory was changed")
+	}
+}
+
+func TestSyncClientLeavesEncryptionParamsAlone(t *testing.T) {
+	local, err := storage.NewLocalBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend, err := storage.NewEncryptedBackend(local, "passphrase", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair := newTestPair(t, backend)
+	pair.writeLocal(storage.EncryptionParamsKey, "my own file")
+	pair.writeLocal("a.txt", "hello")
+
+	client := pair.client()
+	pair.reconcile(client)
+	if cloud, _ := pair.readCloud("a.txt"); cloud != "hello" {
+		t.Fatalf("cloud copy of a.txt holds %q", cloud)
+	}
+	if client.hasFailed(storage.EncryptionParamsKey) {
+		t.Fatalf("%s was synced", storage.EncryptionParamsKey)
+	}
+	if data, _ := pair.readLocal(storage.EncryptionParamsKey); data != "my own file" {
+		t.Fatalf("local %s holds %q", storage.EncryptionParamsKey, data)
+	}
+
+	// The parameters are still in place for the next client.
+	other, _ := storage.NewEncryptedBackend(local, "passphrase", false)
+	body, _, err := other.Get(context.Background(), "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body.Close()
+}
@@ -0,0 +1,139 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/planetsp/k-drive/pkg/logging"
+	s "github.com/planetsp/k-drive/pkg/models"
+	"github.com/planetsp/k-drive/pkg/state"
+	"github.com/planetsp/k-drive/pkg/storage"
+)
+
+// putFile uploads f as key, storing hash with it so downloads can be
+// verified. Multipart uploads are journaled so that, if k-drive stops
+// halfway, the next upload of the unchanged file continues with the parts
+// that already arrived.
+func (client *SyncClient) putFile(ctx context.Context, key string, f *os.File, file os.FileInfo, hash string) (*storage.ObjectInfo, error) {
+	metadata := map[string]string{storage.MetadataSHA256: hash}
+	resumer, ok := client.backend.(storage.UploadResumer)
+	if !ok {
+		return client.backend.Put(ctx, key, f, file.Size(), metadata)
+	}
+
+	uploadID := ""
+	if previous := client.journal.Get(key); previous != nil && previous.Direction == state.TransferUpload &&
+		previous.Size == file.Size() && previous.ModTime.Equal(file.ModTime()) {
+		uploadID = previous.UploadID
+	}
+	info, err := resumer.ResumablePut(ctx, key, f, file.Size(), metadata, uploadID, func(uploadID string) {
+		err := client.journal.Put(state.Transfer{
+			Key:       key,
+			Direction: state.TransferUpload,
+			Size:      file.Size(),
+			ModTime:   file.ModTime(),
+			UploadID:  uploadID,
+			StartedAt: time.Now(),
+		})
+		if err != nil {
+			log.Error(fmt.Sprintf("failed to journal upload of %q, %v", key, err))
+		}
+	})
+	if err == nil {
+		client.forgetTransfer(key)
+	}
+	return info, err
+}
+
+// openDownload returns the temporary file to download info into and the
+// chunks of it that are already filled in, continuing a journaled download
+// of the same object version if there is one.
+func (client *SyncClient) openDownload(info *storage.ObjectInfo, localPath string) (*os.File, map[int64]bool, error) {
+	previous := client.journal.Get(info.Key)
+	if previous != nil && previous.Direction == state.TransferDownload {
+		if previous.ETag == info.ETag && previous.Size == info.Size &&
+			filepath.Dir(previous.TempPath) == filepath.Dir(localPath) {
+			if f, err := os.OpenFile(previous.TempPath, os.O_WRONLY, 0644); err == nil {
+				done := map[int64]bool{}
+				for _, offset := range previous.Chunks {
+					done[offset] = true
+				}
+				log.Info("resuming download of %q, %d chunks already downloaded", info.Key, len(done))
+				return f, done, nil
+			}
+		}
+		os.Remove(previous.TempPath)
+	}
+
+	f, err := createDownloadTempFile(localPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = client.journal.Put(state.Transfer{
+		Key:       info.Key,
+		Direction: state.TransferDownload,
+		Size:      info.Size,
+		ETag:      info.ETag,
+		TempPath:  f.Name(),
+		StartedAt: time.Now(),
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("failed to journal download of %q, %v", info.Key, err))
+	}
+	return f, map[int64]bool{}, nil
+}
+
+func (client *SyncClient) forgetTransfer(key string) {
+	if err := client.journal.Delete(key); err != nil {
+		log.Error(fmt.Sprintf("failed to update transfer journal, %v", err))
+	}
+}
+
+// resumeTransfers restarts the transfers that were interrupted when k-drive
+// last stopped, as long as neither side changed since. It returns the upload
+// IDs that are being continued so they are not cleaned up as abandoned.
+func (client *SyncClient) resumeTransfers() map[string]bool {
+	resumed := map[string]bool{}
+	tempFiles := map[string]bool{}
+	for _, transfer := range client.journal.All() {
+		switch transfer.Direction {
+		case state.TransferUpload:
+			var local *localFile
+			localPath, err := LocalPathForKey(client.workingDirectory, transfer.Key)
+			if err == nil {
+				local, err = statLocalFile(localPath)
+			}
+			if err == nil && local != nil && local.Size == transfer.Size && local.ModTime.Equal(transfer.ModTime) {
+				resumed[transfer.UploadID] = true
+				client.runTransfer(transfer.Key, transfer.Size, s.Local, client.UploadFileToCloud)
+				continue
+			}
+		case state.TransferDownload:
+			cloud, err := client.backend.Stat(client.ctx, transfer.Key)
+			if err == nil && cloud.ETag == transfer.ETag {
+				tempFiles[transfer.TempPath] = true
+				client.runTransfer(transfer.Key, transfer.Size, s.Cloud, client.DownloadFileFromCloud)
+				continue
+			}
+			os.Remove(transfer.TempPath)
+		}
+		log.Info("%s of %q is outdated, not resuming it", transfer.Direction, transfer.Key)
+		client.forgetTransfer(transfer.Key)
+	}
+	client.removeStaleDownloads(tempFiles)
+	return resumed
+}
+
+// removeStaleDownloads deletes temporary download files left behind by a
+// crash that cannot be resumed.
+func (client *SyncClient) removeStaleDownloads(keep map[string]bool) {
+	filepath.Walk(client.workingDirectory, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && isDownloadTempFile(path) && !keep[path] {
+			os.Remove(path)
+		}
+		return nil
+	})
+}
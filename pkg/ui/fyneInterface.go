@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/planetsp/k-drive/pkg/logging"
@@ -23,10 +24,12 @@ import (
 )
 
 var tableData = [][]string{
-	{"Filename", "Date Modified", "Location", "Status"}}
+	{"Filename", "Date Modified", "Location", "Status", "Sync Pair"}}
 var cloudProvider string
 var workingDirectory string
 var configReady = make(chan bool, 1)
+var retryHandler func()
+var connectionLabel = widget.NewLabel("Connecting to the cloud...")
 
 func init() {
 	// Initialize variables safely
@@ -50,40 +53,189 @@ func RunUI() {
 
 	// Check if configuration is loaded
 	if !c.IsConfigLoaded() {
-		showConfigDialog(myApp, myWindow)
+		showConfigDialog(myApp, myWindow, false)
 	} else {
-		configReady <- true
+		signalConfigReady()
 	}
 
-	grid := container.New(layout.NewGridLayout(1), makeHeader(), makeFileList(), container.NewMax())
+	pairRow := container.NewBorder(nil, nil, widget.NewLabel("Sync Pair:"), nil, makePairSelect())
+	header := container.NewVBox(makeHeader(), connectionLabel, pairRow)
+	fileList = makeFileList(myWindow)
+	grid := container.New(layout.NewGridLayout(1), header, fileList, container.NewMax())
 	myWindow.SetContent(grid)
 	myWindow.ShowAndRun()
 }
 
+// SetRetryHandler sets what the "Retry Failed Transfers" menu item does.
+func SetRetryHandler(handler func()) {
+	retryHandler = handler
+}
+
 func GetConfigReadyChannel() <-chan bool {
 	return configReady
 }
 
-func showConfigDialog(app fyne.App, parentWindow fyne.Window) {
+// showConfigDialog edits the sync pair picked in the pair selector, or a new
+// one if addPair is set, along with the settings shared by all pairs.
+func showConfigDialog(app fyne.App, parentWindow fyne.Window, addPair bool) {
 	configWindow := app.NewWindow("Configuration Required")
 	configWindow.Resize(fyne.NewSize(500, 400))
 	configWindow.SetFixedSize(true)
 
 	config := c.CreateDefaultConfig()
+	if c.IsConfigLoaded() {
+		// Edit a copy, the configuration in use only changes on save.
+		loaded := *c.GetConfig()
+		loaded.SyncPairs = append([]c.SyncPair{}, loaded.SyncPairs...)
+		config = &loaded
+	}
+	pair := selectedPair(config)
+	if addPair {
+		newPair := c.NewSyncPair()
+		pair = &newPair
+	}
+
+	nameEntry := widget.NewEntry()
+	nameEntry.SetText(pair.Name)
+	nameEntry.SetPlaceHolder("e.g., Documents (leave empty to use the folder name)")
 
 	// Create form entries
 	workingDirEntry := widget.NewEntry()
-	workingDirEntry.SetText(config.WorkingDirectory)
+	workingDirEntry.SetText(pair.WorkingDirectory)
 	workingDirEntry.SetPlaceHolder("e.g., /home/user/sync-folder/")
 
 	bucketEntry := widget.NewEntry()
-	bucketEntry.SetText(config.BucketName)
+	bucketEntry.SetText(pair.BucketName)
 	bucketEntry.SetPlaceHolder("e.g., my-sync-bucket")
 
+	prefixEntry := widget.NewEntry()
+	prefixEntry.SetText(pair.RemotePrefix)
+	prefixEntry.SetPlaceHolder("e.g., laptop/ (leave empty for the whole bucket)")
+
+	endpointEntry := widget.NewEntry()
+	endpointEntry.SetText(pair.EndpointURL)
+	endpointEntry.SetPlaceHolder("e.g., https://minio.example.com:9000 (leave empty for AWS)")
+
+	regionEntry := widget.NewEntry()
+	regionEntry.SetText(pair.Region)
+	regionEntry.SetPlaceHolder("e.g., us-east-1 (leave empty to use ~/.aws/config)")
+
+	pathStyleCheck := widget.NewCheck("Use path-style addressing", nil)
+	pathStyleCheck.SetChecked(pair.UsePathStyle)
+
+	skipTLSVerifyCheck := widget.NewCheck("Skip TLS certificate verification", nil)
+	skipTLSVerifyCheck.SetChecked(pair.InsecureSkipTLSVerify)
+
+	const sseNone = "None"
+	sseSelect := widget.NewSelect([]string{sseNone, c.SSES3, c.SSEKMS, c.SSEC}, nil)
+	sseSelect.SetSelected(pair.ServerSideEncryption)
+	if sseSelect.Selected == "" {
+		sseSelect.SetSelected(sseNone)
+	}
+
+	kmsKeyEntry := widget.NewEntry()
+	kmsKeyEntry.SetText(pair.KMSKeyID)
+	kmsKeyEntry.SetPlaceHolder("e.g., arn:aws:kms:... (leave empty for the AWS managed key)")
+
+	customerKeyEntry := widget.NewEntry()
+	customerKeyEntry.SetText(pair.SSECustomerKeyFile)
+	customerKeyEntry.SetPlaceHolder("e.g., /home/user/.kdrive-sse-c.key")
+
+	const storageClassDefault = "Bucket default"
+	storageClassSelect := widget.NewSelect(append([]string{storageClassDefault}, c.StorageClasses...), nil)
+	storageClassSelect.SetSelected(pair.StorageClass)
+	if storageClassSelect.Selected == "" {
+		storageClassSelect.SetSelected(storageClassDefault)
+	}
+
+	storageClassRulesEntry := widget.NewMultiLineEntry()
+	rules := []string{}
+	for _, rule := range pair.StorageClassRules {
+		rules = append(rules, rule.Pattern+" "+rule.StorageClass)
+	}
+	storageClassRulesEntry.SetText(strings.Join(rules, "\n"))
+	storageClassRulesEntry.SetPlaceHolder("archive/ GLACIER_IR\n*.iso STANDARD_IA")
+
+	s3Widgets := []fyne.Disableable{bucketEntry, endpointEntry, regionEntry, pathStyleCheck, skipTLSVerifyCheck,
+		sseSelect, kmsKeyEntry, customerKeyEntry, storageClassSelect, storageClassRulesEntry}
+
+	localBackendEntry := widget.NewEntry()
+	localBackendEntry.SetText(pair.LocalBackendDirectory)
+	localBackendEntry.SetPlaceHolder("e.g., /mnt/nas/k-drive/")
+
+	providerSelect := widget.NewSelect([]string{c.ProviderAwsS3, c.ProviderLocal}, func(provider string) {
+		for _, w := range s3Widgets {
+			if provider == c.ProviderLocal {
+				w.Disable()
+			} else {
+				w.Enable()
+			}
+		}
+		if provider == c.ProviderLocal {
+			localBackendEntry.Enable()
+		} else {
+			localBackendEntry.Disable()
+		}
+	})
+	providerSelect.SetSelected(pair.CloudProvider)
+
+	conflictSelect := widget.NewSelect([]string{c.ConflictKeepBoth, c.ConflictNewestWins, c.ConflictLocalWins, c.ConflictCloudWins}, nil)
+	conflictSelect.SetSelected(pair.ConflictPolicy)
+	if conflictSelect.Selected == "" {
+		conflictSelect.SetSelected(c.ConflictKeepBoth)
+	}
+
+	directionSelect := widget.NewSelect([]string{c.DirectionTwoWay, c.DirectionUploadOnly, c.DirectionDownloadOnly}, nil)
+	directionSelect.SetSelected(pair.SyncDirection)
+	if directionSelect.Selected == "" {
+		directionSelect.SetSelected(c.DirectionTwoWay)
+	}
+
+	savedPassphrase, err := c.LoadPassphrase(pair.ID)
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to read encryption passphrase: %v", err))
+	}
+	passphraseEntry := widget.NewPasswordEntry()
+	repeatPassphraseEntry := widget.NewPasswordEntry()
+	if savedPassphrase != "" {
+		passphraseEntry.SetPlaceHolder("leave empty to keep the saved passphrase")
+	}
+	encryptNamesCheck := widget.NewCheck("Encrypt file and folder names", nil)
+	encryptNamesCheck.SetChecked(pair.EncryptFilenames)
+	encryptCheck := widget.NewCheck("Encrypt files before uploading", func(checked bool) {
+		for _, w := range []fyne.Disableable{passphraseEntry, repeatPassphraseEntry, encryptNamesCheck} {
+			if checked {
+				w.Enable()
+			} else {
+				w.Disable()
+			}
+		}
+	})
+	encryptCheck.SetChecked(pair.Encrypt)
+	encryptCheck.OnChanged(pair.Encrypt)
+
 	pollingEntry := widget.NewEntry()
 	pollingEntry.SetText(strconv.Itoa(int(config.LocalDirectoryPollingFrequency)))
 	pollingEntry.SetPlaceHolder("3")
 
+	workersEntry := widget.NewEntry()
+	if config.TransferWorkers > 0 {
+		workersEntry.SetText(strconv.Itoa(config.TransferWorkers))
+	}
+	workersEntry.SetPlaceHolder("4")
+
+	pinnedEntry := widget.NewMultiLineEntry()
+	pinnedEntry.SetText(strings.Join(pair.PinnedPaths, "\n"))
+	pinnedEntry.SetPlaceHolder("e.g., Documents/urgent")
+
+	ignorePatterns := pair.IgnorePatterns
+	if ignorePatterns == nil {
+		ignorePatterns = c.DefaultIgnorePatterns
+	}
+	ignoreEntry := widget.NewMultiLineEntry()
+	ignoreEntry.SetText(strings.Join(ignorePatterns, "\n"))
+	ignoreEntry.SetPlaceHolder("e.g., node_modules/")
+
 	// Browse button for working directory
 	browseBtn := widget.NewButton("Browse", func() {
 		dialog.ShowFolderOpen(func(uri fyne.ListableURI, err error) {
@@ -93,6 +245,14 @@ func showConfigDialog(app fyne.App, parentWindow fyne.Window) {
 		}, parentWindow)
 	})
 
+	browseLocalBackendBtn := widget.NewButton("Browse", func() {
+		dialog.ShowFolderOpen(func(uri fyne.ListableURI, err error) {
+			if err == nil && uri != nil {
+				localBackendEntry.SetText(uri.Path())
+			}
+		}, parentWindow)
+	})
+
 	// Create and validate directory button
 	createDirBtn := widget.NewButton("Create Directory", func() {
 		path := workingDirEntry.Text
@@ -111,20 +271,101 @@ func showConfigDialog(app fyne.App, parentWindow fyne.Window) {
 		widget.NewLabel("K-Drive Configuration"),
 		widget.NewSeparator(),
 
+		widget.NewLabel("Sync Pair Name:"),
+		nameEntry,
+
+		widget.NewLabel(""),
 		widget.NewLabel("Working Directory:"),
 		container.NewBorder(nil, nil, nil, container.NewHBox(browseBtn, createDirBtn), workingDirEntry),
 		widget.NewLabel("This is the local folder that will be synchronized with the cloud."),
 
+		widget.NewLabel(""),
+		widget.NewLabel("Cloud Provider:"),
+		providerSelect,
+		widget.NewLabel("Where the working directory is synchronized to."),
+
 		widget.NewLabel(""),
 		widget.NewLabel("S3 Bucket Name:"),
 		bucketEntry,
 		widget.NewLabel("The AWS S3 bucket name for cloud storage."),
 
+		widget.NewLabel(""),
+		widget.NewLabel("Remote Prefix:"),
+		prefixEntry,
+		widget.NewLabel("The folder in the bucket or backend directory the working directory is synced to."),
+
+		widget.NewLabel(""),
+		widget.NewLabel("S3 Endpoint URL:"),
+		endpointEntry,
+		widget.NewLabel("Only needed for S3-compatible stores such as MinIO or Ceph."),
+
+		widget.NewLabel(""),
+		widget.NewLabel("Region:"),
+		regionEntry,
+		pathStyleCheck,
+		skipTLSVerifyCheck,
+
+		widget.NewLabel(""),
+		widget.NewLabel("Server-Side Encryption:"),
+		sseSelect,
+		widget.NewLabel("KMS Key ID:"),
+		kmsKeyEntry,
+		widget.NewLabel("SSE-C Key File:"),
+		customerKeyEntry,
+		widget.NewLabel("How S3 encrypts stored objects. SSE-C needs a file holding a 256-bit key, raw or base64 encoded."),
+
+		widget.NewLabel(""),
+		widget.NewLabel("Storage Class:"),
+		storageClassSelect,
+		widget.NewLabel("Storage Class Rules (one per line):"),
+		storageClassRulesEntry,
+		widget.NewLabel("A pattern written like .gitignore followed by a storage class, the last matching rule wins."),
+
+		widget.NewLabel(""),
+		widget.NewLabel("Target Directory:"),
+		container.NewBorder(nil, nil, nil, browseLocalBackendBtn, localBackendEntry),
+		widget.NewLabel("For the local provider, e.g. a mounted NAS share used instead of the cloud."),
+
 		widget.NewLabel(""),
 		widget.NewLabel("Polling Frequency (seconds):"),
 		pollingEntry,
 		widget.NewLabel("How often to check for changes in the cloud."),
 
+		widget.NewLabel(""),
+		widget.NewLabel("Parallel Transfers:"),
+		workersEntry,
+		widget.NewLabel("How many files are uploaded or downloaded at the same time."),
+
+		widget.NewLabel(""),
+		widget.NewLabel("Pinned Paths (one per line):"),
+		pinnedEntry,
+		widget.NewLabel("Files and folders that are transferred before anything else."),
+
+		widget.NewLabel(""),
+		widget.NewLabel("Ignore Patterns (one per line):"),
+		ignoreEntry,
+		widget.NewLabel("Files that are never synced, written like .gitignore. A .kdriveignore file in the working directory adds more."),
+
+		widget.NewLabel(""),
+		widget.NewLabel("Sync Direction:"),
+		directionSelect,
+		widget.NewLabel("Upload only backs the folder up without ever deleting or downloading, download only mirrors the cloud."),
+
+		widget.NewLabel(""),
+		widget.NewLabel("Encryption:"),
+		encryptCheck,
+		encryptNamesCheck,
+		widget.NewLabel("Passphrase:"),
+		passphraseEntry,
+		widget.NewLabel("Repeat Passphrase:"),
+		repeatPassphraseEntry,
+		widget.NewLabel("Use the same passphrase on every computer, it cannot be recovered. Changing these settings syncs the folder anew, best into an empty bucket or remote prefix."),
+
+		widget.NewLabel(""),
+		widget.NewLabel("Conflict Resolution:"),
+		conflictSelect,
+		widget.NewLabel("What to do with a file that was changed both locally and in the cloud."),
+
 		widget.NewLabel(""),
 		widget.NewSeparator(),
 	)
@@ -132,7 +373,9 @@ func showConfigDialog(app fyne.App, parentWindow fyne.Window) {
 	saveBtn := widget.NewButton("Save & Start", func() {
 		// Validate inputs
 		workingDir := workingDirEntry.Text
+		provider := providerSelect.Selected
 		bucketName := bucketEntry.Text
+		localBackendDir := localBackendEntry.Text
 		pollingStr := pollingEntry.Text
 
 		if workingDir == "" {
@@ -140,7 +383,12 @@ func showConfigDialog(app fyne.App, parentWindow fyne.Window) {
 			return
 		}
 
-		if bucketName == "" {
+		if provider == c.ProviderLocal {
+			if localBackendDir == "" || !filepath.IsAbs(localBackendDir) {
+				dialog.ShowError(fmt.Errorf("Target directory must be an absolute path"), configWindow)
+				return
+			}
+		} else if bucketName == "" {
 			dialog.ShowError(fmt.Errorf("S3 bucket name is required"), configWindow)
 			return
 		}
@@ -151,6 +399,101 @@ func showConfigDialog(app fyne.App, parentWindow fyne.Window) {
 			return
 		}
 
+		workers := 0
+		if workersEntry.Text != "" {
+			workers, err = strconv.Atoi(workersEntry.Text)
+			if err != nil || workers <= 0 {
+				dialog.ShowError(fmt.Errorf("Parallel transfers must be a positive number"), configWindow)
+				return
+			}
+		}
+
+		passphrase := passphraseEntry.Text
+		if encryptCheck.Checked {
+			if passphrase != repeatPassphraseEntry.Text {
+				dialog.ShowError(fmt.Errorf("The passphrases do not match"), configWindow)
+				return
+			}
+			if passphrase == "" && savedPassphrase == "" {
+				dialog.ShowError(fmt.Errorf("A passphrase is required for encryption"), configWindow)
+				return
+			}
+		}
+
+		storageClassRules := []c.StorageClassRule{}
+		for _, line := range strings.Split(storageClassRulesEntry.Text, "\n") {
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+			i := strings.LastIndexAny(line, " \t")
+			if i < 0 {
+				dialog.ShowError(fmt.Errorf("Storage class rule %q needs a pattern and a storage class", line), configWindow)
+				return
+			}
+			storageClassRules = append(storageClassRules, c.StorageClassRule{
+				Pattern:      strings.TrimSpace(line[:i]),
+				StorageClass: strings.ToUpper(line[i+1:]),
+			})
+		}
+
+		pinned := []string{}
+		for _, line := range strings.Split(pinnedEntry.Text, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				pinned = append(pinned, filepath.ToSlash(line))
+			}
+		}
+
+		ignored := []string{}
+		for _, line := range strings.Split(ignoreEntry.Text, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				ignored = append(ignored, line)
+			}
+		}
+
+		pair.Name = strings.TrimSpace(nameEntry.Text)
+		pair.WorkingDirectory = workingDir
+		pair.CloudProvider = provider
+		pair.BucketName = bucketName
+		pair.RemotePrefix = strings.Trim(filepath.ToSlash(strings.TrimSpace(prefixEntry.Text)), "/")
+		pair.EndpointURL = endpointEntry.Text
+		pair.Region = regionEntry.Text
+		pair.UsePathStyle = pathStyleCheck.Checked
+		pair.InsecureSkipTLSVerify = skipTLSVerifyCheck.Checked
+		pair.ServerSideEncryption = ""
+		if sseSelect.Selected != sseNone {
+			pair.ServerSideEncryption = sseSelect.Selected
+		}
+		pair.KMSKeyID = ""
+		if pair.ServerSideEncryption == c.SSEKMS {
+			pair.KMSKeyID = strings.TrimSpace(kmsKeyEntry.Text)
+		}
+		pair.SSECustomerKeyFile = ""
+		if pair.ServerSideEncryption == c.SSEC {
+			pair.SSECustomerKeyFile = strings.TrimSpace(customerKeyEntry.Text)
+		}
+		pair.StorageClass = ""
+		if storageClassSelect.Selected != storageClassDefault {
+			pair.StorageClass = storageClassSelect.Selected
+		}
+		pair.StorageClassRules = storageClassRules
+		pair.LocalBackendDirectory = localBackendDir
+		config.LocalDirectoryPollingFrequency = time.Duration(pollingFreq)
+		pair.ConflictPolicy = conflictSelect.Selected
+		pair.SyncDirection = directionSelect.Selected
+		pair.Encrypt = encryptCheck.Checked
+		pair.EncryptFilenames = encryptCheck.Checked && encryptNamesCheck.Checked
+		config.TransferWorkers = workers
+		pair.PinnedPaths = pinned
+		pair.IgnorePatterns = ignored
+		if addPair && pair.ID == "" {
+			pair.ID = c.NewPairID()
+			config.SyncPairs = append(config.SyncPairs, *pair)
+			pair = &config.SyncPairs[len(config.SyncPairs)-1]
+		}
+		if !pair.Encrypt {
+			passphrase = ""
+		}
+
 		// Ensure working directory exists
 		if _, err := os.Stat(workingDir); os.IsNotExist(err) {
 			dialog.ShowConfirm("Directory doesn't exist",
@@ -162,16 +505,20 @@ func showConfigDialog(app fyne.App, parentWindow fyne.Window) {
 							dialog.ShowError(fmt.Errorf("Failed to create directory: %v", err), configWindow)
 							return
 						}
-						saveConfiguration(workingDir, bucketName, pollingFreq, configWindow)
+						saveConfiguration(config, pair, passphrase, configWindow)
 					}
 				}, configWindow)
 		} else {
-			saveConfiguration(workingDir, bucketName, pollingFreq, configWindow)
+			saveConfiguration(config, pair, passphrase, configWindow)
 		}
 	})
 
 	cancelBtn := widget.NewButton("Cancel", func() {
-		app.Quit()
+		if c.IsConfigLoaded() {
+			configWindow.Close()
+		} else {
+			app.Quit()
+		}
 	})
 
 	buttonContainer := container.NewHBox(saveBtn, cancelBtn)
@@ -183,23 +530,32 @@ func showConfigDialog(app fyne.App, parentWindow fyne.Window) {
 	configWindow.Show()
 }
 
-func saveConfiguration(workingDir, bucketName string, pollingFreq int, configWindow fyne.Window) {
+// saveConfiguration validates and saves config after pair was edited, along
+// with a new passphrase for it unless that is "".
+func saveConfiguration(config *c.Configuration, pair *c.SyncPair, passphrase string, configWindow fyne.Window) {
 	// Ensure working directory ends with separator
+	workingDir := pair.WorkingDirectory
 	if !filepath.IsAbs(workingDir) {
 		dialog.ShowError(fmt.Errorf("Working directory must be an absolute path"), configWindow)
 		return
 	}
 
 	if workingDir[len(workingDir)-1] != filepath.Separator {
-		workingDir += string(filepath.Separator)
+		pair.WorkingDirectory += string(filepath.Separator)
+	}
+
+	if err := c.ValidateConfig(config); err != nil {
+		dialog.ShowError(err, configWindow)
+		return
 	}
 
-	config := &c.Configuration{
-		AppName:                        "K-Drive",
-		WorkingDirectory:               workingDir,
-		CloudProvider:                  "aws s3",
-		BucketName:                     bucketName,
-		LocalDirectoryPollingFrequency: time.Duration(pollingFreq),
+	// The passphrase goes first, a saved configuration that encrypts
+	// without one fails to sync.
+	if passphrase != "" {
+		if err := c.SavePassphrase(pair.ID, passphrase); err != nil {
+			dialog.ShowError(fmt.Errorf("Failed to save passphrase: %v", err), configWindow)
+			return
+		}
 	}
 
 	err := c.SaveConfig(config)
@@ -211,21 +567,36 @@ func saveConfiguration(workingDir, bucketName string, pollingFreq int, configWin
 	// Update global variables
 	cloudProvider = config.CloudProvider
 	workingDirectory = config.WorkingDirectory
+	refreshPairSelect()
 
-	dialog.ShowInformation("Success", "Configuration saved successfully! The sync client will now start.", configWindow)
+	dialog.ShowInformation("Success", "Configuration saved successfully! Sync pairs with changed settings restart with them now.", configWindow)
 	configWindow.Close()
 
-	// Signal that config is ready
-	configReady <- true
+	signalConfigReady()
+}
+
+// signalConfigReady tells the sync clients to pick up the saved
+// configuration. A signal that is still pending covers this one as well.
+func signalConfigReady() {
+	select {
+	case configReady <- true:
+	default:
+	}
 }
 func AddSyncInfoToFyneTable(syncInfo *s.SyncInfo) {
 	log.Info("Adding %s to Fyne Table", syncInfo.Filename, syncInfo.Location)
+	status := syncInfo.SyncStatus.String()
+	if syncInfo.Reason != "" {
+		status += ": " + syncInfo.Reason
+	}
 	slice := []string{syncInfo.Filename,
 		syncInfo.DateModified.Format("Mon Jan _2 15:04:05 2006"),
 		syncInfo.Location.String(),
-		syncInfo.SyncStatus.String()}
-	tableData = append(tableData, slice)
+		status,
+		pairName(syncInfo.Pair)}
+	addSyncRow(syncInfo, slice)
 }
+
 func SetWorkingDirectory(workingDir string) {
 	workingDirectory = workingDir
 }
@@ -239,7 +610,7 @@ The local working directory is "%s".`, cloudProvider, workingDirectory)
 	return appInfo
 }
 
-func makeFileList() *widget.Table {
+func makeFileList(w fyne.Window) *widget.Table {
 	list := widget.NewTable(
 		func() (int, int) {
 			return len(tableData), len(tableData[0])
@@ -254,6 +625,14 @@ func makeFileList() *widget.Table {
 				o.(*widget.Label).TextStyle.Bold = true
 			}
 		})
+	// Labels are cut short, show the whole reason of a failed transfer
+	list.OnSelected = func(id widget.TableCellID) {
+		row := tableData[id.Row]
+		if id.Row > 0 && strings.HasPrefix(row[3], s.Error.String()+": ") {
+			dialog.ShowInformation("Transfer failed", row[0]+"\n\n"+strings.TrimPrefix(row[3], s.Error.String()+": "), w)
+		}
+		list.Unselect(id)
+	}
 	return list
 }
 
@@ -281,7 +660,21 @@ func makeMenu(a fyne.App, w fyne.Window) *fyne.MainMenu {
 	})
 
 	configItem := fyne.NewMenuItem("Configuration", func() {
-		showConfigDialog(a, w)
+		showConfigDialog(a, w, false)
+	})
+	addPairItem := fyne.NewMenuItem("Add Sync Pair", func() {
+		showConfigDialog(a, w, true)
+	})
+	removePairItem := fyne.NewMenuItem("Remove Sync Pair", func() {
+		showRemovePairDialog(w)
+	})
+	selectiveSyncItem := fyne.NewMenuItem("Selective Sync", func() {
+		showSelectiveSyncDialog(a, w)
+	})
+	retryItem := fyne.NewMenuItem("Retry Failed Transfers", func() {
+		if retryHandler != nil {
+			retryHandler()
+		}
 	})
 
 	cutItem := fyne.NewMenuItem("Cut", func() {
@@ -319,7 +712,7 @@ func makeMenu(a fyne.App, w fyne.Window) *fyne.MainMenu {
 	// a quit item will be appended to our first (File) menu
 	file := fyne.NewMenu("File", newItem, checkedItem, disabledItem)
 	if !fyne.CurrentDevice().IsMobile() {
-		file.Items = append(file.Items, fyne.NewMenuItemSeparator(), configItem, settingsItem)
+		file.Items = append(file.Items, fyne.NewMenuItemSeparator(), retryItem, selectiveSyncItem, configItem, addPairItem, removePairItem, settingsItem)
 	}
 	return fyne.NewMainMenu(
 		file,
@@ -0,0 +1,192 @@
+package ui
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	c "github.com/planetsp/k-drive/pkg/config"
+	log "github.com/planetsp/k-drive/pkg/logging"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+var listFoldersHandler func(pairID string) ([]string, error)
+var selectFoldersHandler func(pairID string, folders []string)
+
+// SetSelectiveSyncHandlers sets how the folder picker lists the folders in
+// the cloud of a sync pair and applies a new selection.
+func SetSelectiveSyncHandlers(listFolders func(pairID string) ([]string, error), selectFolders func(pairID string, folders []string)) {
+	listFoldersHandler = listFolders
+	selectFoldersHandler = selectFolders
+}
+
+// folderSelection is the state of the folder picker. A folder is synced if
+// it or one of its parents is selected.
+type folderSelection struct {
+	children map[string][]string
+	selected map[string]bool
+}
+
+func newFolderSelection(folders []string, selected []string) *folderSelection {
+	selection := &folderSelection{children: map[string][]string{}, selected: map[string]bool{}}
+	for _, folder := range folders {
+		parent := path.Dir(folder)
+		if parent == "." {
+			parent = ""
+		}
+		selection.children[parent] = append(selection.children[parent], folder)
+	}
+	for _, children := range selection.children {
+		sort.Strings(children)
+	}
+	if selected == nil {
+		// No selection at all means everything is synced.
+		for _, folder := range selection.children[""] {
+			selection.selected[folder] = true
+		}
+	}
+	for _, folder := range selected {
+		selection.selected[folder] = true
+	}
+	return selection
+}
+
+func (selection *folderSelection) isSelected(folder string) bool {
+	for ; folder != "."; folder = path.Dir(folder) {
+		if selection.selected[folder] {
+			return true
+		}
+	}
+	return false
+}
+
+func (selection *folderSelection) check(folder string) {
+	for other := range selection.selected {
+		if strings.HasPrefix(other, folder+"/") {
+			delete(selection.selected, other)
+		}
+	}
+	selection.selected[folder] = true
+}
+
+// uncheck deselects folder. If it was only selected through a parent, the
+// parent is replaced by all its other subfolders.
+func (selection *folderSelection) uncheck(folder string) {
+	if selection.selected[folder] {
+		delete(selection.selected, folder)
+		return
+	}
+	ancestor := path.Dir(folder)
+	for !selection.selected[ancestor] {
+		if ancestor == "." {
+			return
+		}
+		ancestor = path.Dir(ancestor)
+	}
+	delete(selection.selected, ancestor)
+	for parent := ancestor; parent != folder; {
+		var next string
+		for _, child := range selection.children[parent] {
+			if child == folder || strings.HasPrefix(folder, child+"/") {
+				next = child
+			} else {
+				selection.selected[child] = true
+			}
+		}
+		parent = next
+	}
+}
+
+// folders returns the selection to store, nil if every top-level folder is
+// selected so folders created later are synced as well.
+func (selection *folderSelection) folders() []string {
+	all := true
+	for _, folder := range selection.children[""] {
+		all = all && selection.selected[folder]
+	}
+	if all {
+		return nil
+	}
+	folders := []string{}
+	for folder := range selection.selected {
+		folders = append(folders, folder)
+	}
+	sort.Strings(folders)
+	return folders
+}
+
+func showSelectiveSyncDialog(app fyne.App, parentWindow fyne.Window) {
+	if listFoldersHandler == nil {
+		return
+	}
+	config := c.GetConfig()
+	pair := selectedPair(config)
+	folders, err := listFoldersHandler(pair.ID)
+	if err != nil {
+		dialog.ShowError(err, parentWindow)
+		return
+	}
+	selection := newFolderSelection(folders, pair.SelectedFolders)
+
+	pickerWindow := app.NewWindow("Selective Sync: " + pair.DisplayName())
+	var tree *widget.Tree
+	tree = widget.NewTree(
+		func(id widget.TreeNodeID) []widget.TreeNodeID {
+			return selection.children[id]
+		},
+		func(id widget.TreeNodeID) bool {
+			return id == "" || len(selection.children[id]) > 0
+		},
+		func(branch bool) fyne.CanvasObject {
+			return widget.NewCheck("", nil)
+		},
+		func(id widget.TreeNodeID, branch bool, item fyne.CanvasObject) {
+			check := item.(*widget.Check)
+			check.OnChanged = nil
+			check.Text = path.Base(id)
+			check.SetChecked(selection.isSelected(id))
+			check.Refresh()
+			check.OnChanged = func(checked bool) {
+				if checked {
+					selection.check(id)
+				} else {
+					selection.uncheck(id)
+				}
+				tree.Refresh()
+			}
+		})
+
+	saveBtn := widget.NewButton("Save", func() {
+		dialog.ShowConfirm("Selective Sync",
+			"Local copies of folders that are not selected will be removed, the cloud keeps them. Local changes are uploaded first.",
+			func(ok bool) {
+				if !ok {
+					return
+				}
+				pair.SelectedFolders = selection.folders()
+				if err := c.SaveConfig(config); err != nil {
+					log.Error(fmt.Sprintf("Failed to save configuration: %v", err))
+					dialog.ShowError(err, pickerWindow)
+					return
+				}
+				if selectFoldersHandler != nil {
+					selectFoldersHandler(pair.ID, pair.SelectedFolders)
+				}
+				pickerWindow.Close()
+			}, pickerWindow)
+	})
+	cancelBtn := widget.NewButton("Cancel", func() {
+		pickerWindow.Close()
+	})
+
+	header := widget.NewLabel("Choose the folders to keep on this computer. Files outside of folders are always synced. Files created in a folder that is not selected stay on this computer and are not uploaded.")
+	header.Wrapping = fyne.TextWrapWord
+	pickerWindow.SetContent(container.NewBorder(header, container.NewHBox(saveBtn, cancelBtn), nil, nil, tree))
+	pickerWindow.Resize(fyne.NewSize(500, 500))
+	pickerWindow.Show()
+}
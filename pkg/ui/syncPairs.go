@@ -0,0 +1,186 @@
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	c "github.com/planetsp/k-drive/pkg/config"
+	log "github.com/planetsp/k-drive/pkg/logging"
+	s "github.com/planetsp/k-drive/pkg/models"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+const allPairsOption = "All Sync Pairs"
+
+var pairSelect *widget.Select
+var fileList *widget.Table
+var showingAllPairs = true
+var currentPairID string
+
+// pairOnline is updated by the sync clients of all pairs at once.
+var pairOnline = map[string]bool{}
+var pairOnlineMu sync.Mutex
+
+// pairOptions maps the options of the pair selector to sync pair IDs.
+var pairOptions = map[string]string{}
+
+// syncRows holds the rows of every sync pair, tableData only those of the
+// pairs currently shown.
+var syncRows []syncRow
+
+type syncRow struct {
+	pairID string
+	cells  []string
+}
+
+func pairName(pairID string) string {
+	if pair := c.GetConfig().Pair(pairID); pair != nil {
+		return pair.DisplayName()
+	}
+	return pairID
+}
+
+// selectedPair returns the sync pair picked in the pair selector, the first
+// pair while all of them are shown.
+func selectedPair(config *c.Configuration) *c.SyncPair {
+	if !showingAllPairs {
+		if pair := config.Pair(currentPairID); pair != nil {
+			return pair
+		}
+	}
+	return &config.SyncPair
+}
+
+func makePairSelect() *widget.Select {
+	pairSelect = widget.NewSelect(nil, func(option string) {
+		showingAllPairs = option == allPairsOption
+		if id, ok := pairOptions[option]; ok {
+			currentPairID = id
+		}
+		refreshTable()
+	})
+	refreshPairSelect()
+	return pairSelect
+}
+
+// refreshPairSelect offers the sync pairs of the current configuration.
+func refreshPairSelect() {
+	if pairSelect == nil {
+		return
+	}
+	options := []string{allPairsOption}
+	pairOptions = map[string]string{}
+	selected := allPairsOption
+	if c.IsConfigLoaded() {
+		pairs := c.GetConfig().Pairs()
+		names := map[string]int{}
+		for _, pair := range pairs {
+			names[pair.DisplayName()]++
+		}
+		for _, pair := range pairs {
+			option := pair.DisplayName()
+			if names[option] > 1 {
+				// Folders with the same name in different places
+				option += " (" + pair.WorkingDirectory + ")"
+			}
+			options = append(options, option)
+			pairOptions[option] = pair.ID
+			if !showingAllPairs && pair.ID == currentPairID {
+				selected = option
+			}
+		}
+	}
+	pairSelect.Options = options
+	pairSelect.SetSelected(selected)
+	pairSelect.Refresh()
+}
+
+// refreshTable shows the rows of the selected sync pair, or of all of them.
+func refreshTable() {
+	rows := [][]string{tableData[0]}
+	for _, row := range syncRows {
+		if showingAllPairs || row.pairID == currentPairID {
+			rows = append(rows, row.cells)
+		}
+	}
+	tableData = rows
+	if fileList != nil {
+		fileList.Refresh()
+	}
+}
+
+func addSyncRow(syncInfo *s.SyncInfo, cells []string) {
+	syncRows = append(syncRows, syncRow{pairID: syncInfo.Pair, cells: cells})
+	if showingAllPairs || syncInfo.Pair == currentPairID {
+		tableData = append(tableData, cells)
+	}
+}
+
+// SetOnlineStatus shows whether the cloud of a sync pair is reachable.
+func SetOnlineStatus(pairID string, online bool) {
+	pairOnlineMu.Lock()
+	defer pairOnlineMu.Unlock()
+	pairOnline[pairID] = online
+	if len(c.GetConfig().Pairs()) == 1 {
+		if online {
+			connectionLabel.SetText("Online")
+		} else {
+			connectionLabel.SetText("Offline: changes are queued and synced once the cloud is reachable again")
+		}
+		return
+	}
+
+	statuses := []string{}
+	offline := false
+	for id, online := range pairOnline {
+		status := "Online"
+		if !online {
+			status = "Offline"
+			offline = true
+		}
+		statuses = append(statuses, pairName(id)+": "+status)
+	}
+	sort.Strings(statuses)
+	text := strings.Join(statuses, ", ")
+	if offline {
+		text += " (changes are queued until the cloud is reachable again)"
+	}
+	connectionLabel.SetText(text)
+}
+
+// showRemovePairDialog removes the sync pair picked in the pair selector from
+// the configuration. Its files are left alone, locally and in the cloud.
+func showRemovePairDialog(w fyne.Window) {
+	config := c.GetConfig()
+	pair := selectedPair(config)
+	if showingAllPairs || pair.ID == "" {
+		dialog.ShowInformation("Remove Sync Pair", "Pick the sync pair to remove in the sync pair selector first. The first sync pair cannot be removed.", w)
+		return
+	}
+	id := pair.ID
+	message := fmt.Sprintf("Stop syncing %s? No files are deleted.", pair.DisplayName())
+	dialog.ShowConfirm("Remove Sync Pair", message, func(ok bool) {
+		if !ok {
+			return
+		}
+		pairs := []c.SyncPair{}
+		for _, other := range config.SyncPairs {
+			if other.ID != id {
+				pairs = append(pairs, other)
+			}
+		}
+		config.SyncPairs = pairs
+		if err := c.SaveConfig(config); err != nil {
+			log.Error(fmt.Sprintf("Failed to save configuration: %v", err))
+			dialog.ShowError(err, w)
+			return
+		}
+		refreshPairSelect()
+		signalConfigReady()
+	}, w)
+}